@@ -1,17 +1,22 @@
 module github.com/robinovitch61/webtoon-dl
 
-go 1.20
+go 1.22.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/aherve/gopool v1.0.0
 	github.com/anaskhan96/soup v1.2.5
+	github.com/gen2brain/avif v0.4.0
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/phpdave11/gofpdi v1.0.14-0.20211212211723-1f10f9844311
 	github.com/signintech/gopdf v0.20.0
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa
 )
 
 require (
-	github.com/aherve/gopool v1.0.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-	github.com/phpdave11/gofpdi v1.0.14-0.20211212211723-1f10f9844311 // indirect
+	github.com/ebitengine/purego v0.8.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa // indirect
-	golang.org/x/text v0.3.0 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
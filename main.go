@@ -3,20 +3,46 @@ package main
 import (
     "archive/zip"
     "bytes"
+    "context"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "encoding/binary"
     "encoding/json"
+    "encoding/xml"
     "flag"
     "fmt"
+    "github.com/BurntSushi/toml"
     "github.com/anaskhan96/soup"
+    _ "github.com/gen2brain/avif"
+    "github.com/phpdave11/gofpdi"
     "github.com/signintech/gopdf"
+    "golang.org/x/image/draw"
+    "golang.org/x/image/font"
+    "golang.org/x/image/font/gofont/goregular"
+    "golang.org/x/image/font/opentype"
+    "golang.org/x/image/math/fixed"
+    _ "golang.org/x/image/webp"
+    nethtml "golang.org/x/net/html"
+    "golang.org/x/net/html/charset"
     "image"
+    "image/color"
+    "image/jpeg"
     "io"
     "math"
+    "math/rand"
     "net/http"
     "os"
+    "path"
+    "path/filepath"
     "regexp"
+    "runtime"
     "sort"
     "strconv"
     "strings"
+    "sync"
+    "sync/atomic"
     "time"
     "database/sql"
     _ "github.com/mattn/go-sqlite3"
@@ -26,15 +52,114 @@ import (
     "errors"
 )
 //    "unicode/utf8"
-//    "sync"
+
+// upper bound of -MW, each webtoon also runs -E episode workers
+const maxWebtoonGoroutines = 32
 
 var EpisodeGoroutine    *int
 var WebtoonGoroutine    *int
 var MaxWebtoonGoroutine *bool
 var database            *bool
 var FileVerify          *bool
+var Force               *bool
 var confOverride        *bool
 var NoLog               *bool
+var Doctor              *string
+var ImageQuality        *string
+var SkipUnnumbered      *bool
+var IncludeNotes        *bool
+var NotesFont           *string
+var FailOnGaps          *bool
+var OnlyNew             *bool
+var BatchDelay          *time.Duration
+var Season              *int
+var EpsPerVolume        *int
+var MaxPagesPerFile     *int
+var PrintLinks          *bool
+var JpegQuality         *int
+var ResumeFrom          *int
+var CheckPageCounts     *bool
+var DBFile              *string
+var MaxTotalBytes       *int64
+var Output              *string
+var DecodeWorkers       *int
+var Cover               *bool
+var PageGap             *int
+var MinImageDimension   *int
+var MaxImageDimension   *int
+var SaveHTML            *string
+var AcceptImage         *string
+var Merge               *string
+var MaxListPages        *int
+var ListTimeout         *time.Duration
+var Layout              *string
+var ListWorkers         *int
+var IncludeNotices      *bool
+var CompressPDF         *int
+var CBZEpisodeNames     *bool
+var ValidateOnly        *string
+var Add                 *bool
+var Preview             *int
+var SeriesLog           *bool
+var SortBy              *string
+var UniformWidth        *bool
+var KeepGoingOnDecodeError *bool
+var ListCache           *bool
+var Provenance          *bool
+var Polite              *bool
+var CDNNames            *bool
+var BatchSize           *int
+var InitialLimit        *int
+var SelfCheck           *bool
+
+// hosts images may be fetched from, from -allowed-image-hosts, any host
+// when empty
+var allowedImageHosts []string
+var EpPadWidth          *int
+var ImageDelay          *time.Duration
+var FailFast            *bool
+var ManifestFile        *string
+var Toc                 *bool
+
+// runCtx is cancelled on the first error with -fail-fast, pending
+// requests are aborted and no new batch starts
+var runCtx, cancelRun = context.WithCancel(context.Background())
+var firstError struct {
+    sync.Once
+    err error
+}
+
+// stopOnError records err as the cause of the run failing and cancels
+// runCtx with -fail-fast, a no-op otherwise
+func stopOnError(err error) {
+    if !*FailFast {
+        return
+    }
+    firstError.Do(func() {
+        firstError.err = err
+        cancelRun()
+    })
+}
+
+// sha256 of images known to be placeholders, from -skip-image-hashes
+var skipImageHashes = make(map[string]bool)
+
+// HTTPClient is satisfied by *http.Client, it lets the scraping be run
+// against something other than the live site
+type HTTPClient interface {
+    Do(req *http.Request) (*http.Response, error)
+}
+
+// bounds concurrent image decode/transcode, sized in parseOpts, fetches
+// are not limited by it
+var decodeSem chan struct{}
+
+// bounds the -batch-size chunks fetched at once across files to -E, sized
+// in parseOpts
+var fetchSem chan struct{}
+
+// shared client for pages and images, configured in parseOpts
+var httpClient HTTPClient = &http.Client{}
 
 type MotiontoonJson struct {
     Assets struct {
@@ -43,24 +168,86 @@ type MotiontoonJson struct {
 }
 
 type EpisodeBatch struct {
-    imgLinks []string
-    title    string
-    minEp    int
-    maxEp    int
+    imgLinks   []string
+    notes      []string
+    episodeNos []int
+    // decimal part, title and number of imgLinks of each episode in
+    // episodeNos, see episodeNumber
+    minors     []string
+    titles     []string
+    pageCounts []int
+    // page url of each episode in episodeNos, for -provenance
+    links      []string
+    skipped    []int
+    // decimal part of each episode in skipped
+    skippedMinors []string
+    title      string
+    minEp      int
+    maxEp      int
+}
+
+type BatchResult struct {
+    // position of the batch in the episodeBatches of GetWebtoon
+    index int
+    minEp int
+    maxEp int
+    // episodeLabel of the saved episodes
+    saved []string
+    err   error
+}
+
+// Progress counts pages handled across all the batches of a webtoon so
+// concurrent workers log one coherent total
+type Progress struct {
+    mu    sync.Mutex
+    done  int
+    total int
+}
+
+func newProgress(total int) *Progress {
+    return &Progress{total: total}
+}
+
+// grow adds n pages to handle, e.g. for retried batches
+func (p *Progress) grow(n int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.total += n
+}
+
+// advance marks n more pages as handled and returns the new totals
+func (p *Progress) advance(n int) (int, int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.done += n
+    return p.done, p.total
 }
 
 type EpisodeInfo struct {
     title string
     url string
+    // 0 when the title carries no season label
+    season int
 }
 
 type ComicFile interface {
     addImage([]byte) error
+    addCover([]byte) error
+    addText(text string) error
+    // setSource records where and when the pages were downloaded, firstURL
+    // and lastURL are the pages of the first and last episode
+    setSource(firstURL string, lastURL string, notes string, downloaded time.Time)
     save(outFile string) error
+    // abort removes the temp files of a comic that won't be saved
+    abort()
 }
 
 type PDFComicFile struct {
-    pdf *gopdf.GoPdf
+    pdf        *gopdf.GoPdf
+    fontLoaded bool
+    // image bytes received and embedded, they differ with -compress-pdf
+    inBytes    int
+    outBytes   int
 }
 
 // validate PDFComicFile implements ComicFile
@@ -72,610 +259,4754 @@ func newPDFComicFile() *PDFComicFile {
     return &PDFComicFile{pdf: &pdf}
 }
 
+// addCover adds the cover as a regular first page, pdf has no page types
+func (c *PDFComicFile) addCover(img []byte) error {
+    return c.addImage(img)
+}
+
+// setSource goes to the pdf info dictionary, notes and urls as the subject
+func (c *PDFComicFile) setSource(firstURL string, lastURL string, notes string, downloaded time.Time) {
+    source := firstURL
+    if lastURL != firstURL {
+        source = fmt.Sprintf("%s to %s", firstURL, lastURL)
+    }
+    c.pdf.SetInfo(gopdf.PdfInfo{
+        Subject:      fmt.Sprintf("%s, source %s", notes, source),
+        Creator:      "webtoon-dl",
+        CreationDate: downloaded,
+    })
+}
+
 func (c *PDFComicFile) addImage(img []byte) error {
-    holder, err := gopdf.ImageHolderByBytes(img)
+    // only the header is decoded, gopdf embeds jpeg bytes as they are with
+    // DCTDecode so there is no re-encoding
+    d, _, err := image.DecodeConfig(bytes.NewReader(img))
     if err != nil {
         return err
     }
 
-    d, _, err := image.DecodeConfig(bytes.NewReader(img))
+    c.inBytes += len(img)
+    if *CompressPDF > 0 && *CompressPDF < 128 {
+        img, err = downsample(img, *CompressPDF)
+        if err != nil {
+            return err
+        }
+    }
+    c.outBytes += len(img)
+
+    holder, err := gopdf.ImageHolderByBytes(img)
     if err != nil {
         return err
     }
 
-    // gopdf assumes dpi 128 https://github.com/signintech/gopdf/issues/168
-    // W and H are in points, 1 point = 1/72 inch
-    // convert pixels (Width and Height) to points
-    // subtract 1 point to account for margins
-    c.pdf.AddPageWithOption(gopdf.PageOption{PageSize: &gopdf.Rect{
-        W: float64(d.Width)*72/128 - 1,
-        H: float64(d.Height)*72/128 - 1,
-    }})
-    return c.pdf.ImageByHolder(holder, 0, 0, nil)
+    // W and H are in points, 1 point = 1/72 inch, d is the original size so
+    // downsampled images keep the same page size
+    // convert pixels (Width and Height) to points at 128 dpi, the size gopdf
+    // used to pick https://github.com/signintech/gopdf/issues/168, and draw
+    // the image over the whole page so it does not depend on that assumption
+    page := &gopdf.Rect{
+        W: float64(d.Width) * 72 / 128,
+        H: float64(d.Height) * 72 / 128,
+    }
+    // -page-gap leaves blank space under the image
+    c.pdf.AddPageWithOption(gopdf.PageOption{PageSize: &gopdf.Rect{W: page.W, H: page.H + float64(*PageGap)}})
+    return c.pdf.ImageByHolder(holder, 0, 0, page)
 }
 
-func (c *PDFComicFile) save(outputPath string) error {
-    return c.pdf.WritePdf(outputPath)
-}
+// addText renders text on A4 pages, the bundled Go font covers latin, greek
+// and cyrillic, -notes-font can point to a ttf for other scripts
+func (c *PDFComicFile) addText(text string) error {
+    if !c.fontLoaded {
+        var err error
+        if *NotesFont != "" {
+            err = c.pdf.AddTTFFont("notes", *NotesFont)
+        } else {
+            err = c.pdf.AddTTFFontData("notes", goregular.TTF)
+        }
+        if err != nil {
+            return err
+        }
+        c.fontLoaded = true
+    }
+    if err := c.pdf.SetFont("notes", "", 12); err != nil {
+        return err
+    }
 
-type CBZComicFile struct {
-    zipWriter *zip.Writer
-    buffer    *bytes.Buffer
-    numFiles  int
+    const margin = 36
+    const lineHeight = 16
+    pageSize := *gopdf.PageSizeA4
+    y := pageSize.H
+    for _, paragraph := range strings.Split(text, "\n") {
+        lines, err := c.pdf.SplitText(paragraph, pageSize.W-2*margin)
+        if err != nil {
+            // SplitText fails on empty strings
+            lines = []string{""}
+        }
+        for _, line := range lines {
+            if y+lineHeight > pageSize.H-margin {
+                c.pdf.AddPageWithOption(gopdf.PageOption{PageSize: &pageSize})
+                y = margin
+            }
+            c.pdf.SetXY(margin, y)
+            if err := c.pdf.Cell(nil, line); err != nil {
+                return err
+            }
+            y += lineHeight
+        }
+    }
+    return nil
 }
 
-// validate CBZComicFile implements ComicFile
-var _ ComicFile = &CBZComicFile{}
-
-func newCBZComicFile() (*CBZComicFile, error) {
-    buffer := new(bytes.Buffer)
-    zipWriter := zip.NewWriter(buffer)
-    return &CBZComicFile{zipWriter: zipWriter, buffer: buffer, numFiles: 0}, nil
+// addPDFPages appends every page of an existing pdf at its own size
+func (c *PDFComicFile) addPDFPages(sourceFile string) error {
+    importer := gofpdi.NewImporter()
+    importer.SetSourceFile(sourceFile)
+    sizes := importer.GetPageSizes()
+    for pageNo := 1; pageNo <= importer.GetNumPages(); pageNo++ {
+        box := sizes[pageNo]["/MediaBox"]
+        page := &gopdf.Rect{W: box["w"], H: box["h"]}
+        c.pdf.AddPageWithOption(gopdf.PageOption{PageSize: page})
+        tpl := c.pdf.ImportPage(sourceFile, pageNo, "/MediaBox")
+        c.pdf.UseImportedTemplate(tpl, 0, 0, page.W, page.H)
+    }
+    return nil
 }
 
-func (c *CBZComicFile) addImage(img []byte) error {
-    f, err := c.zipWriter.Create(fmt.Sprintf("%010d.jpg", c.numFiles))
+// downsample scales an image drawn at 128 dpi down to dpi and re-encodes it
+// to jpeg
+func downsample(img []byte, dpi int) ([]byte, error) {
+    decodeSem <- struct{}{}
+    defer func() { <-decodeSem }()
+    src, _, err := image.Decode(bytes.NewReader(img))
     if err != nil {
-        return err
+        return nil, err
     }
-    _, err = f.Write(img)
-    if err != nil {
-        return err
+    bounds := src.Bounds()
+    w := bounds.Dx() * dpi / 128
+    h := bounds.Dy() * dpi / 128
+    if w < 1 || h < 1 {
+        return img, nil
     }
-    c.numFiles++
-    return nil
+    dst := image.NewRGBA(image.Rect(0, 0, w, h))
+    draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+    buff := new(bytes.Buffer)
+    if err := jpeg.Encode(buff, dst, &jpeg.Options{Quality: *JpegQuality}); err != nil {
+        return nil, err
+    }
+    // never make a page bigger than it was
+    if buff.Len() >= len(img) {
+        return img, nil
+    }
+    return buff.Bytes(), nil
 }
 
-func (c *CBZComicFile) save(outputPath string) error {
-    if err := c.zipWriter.Close(); err != nil {
-        return err
+// abort is a no-op, pdf pages are kept in memory until save
+func (c *PDFComicFile) abort() {}
+
+func (c *PDFComicFile) save(outputPath string) error {
+    if *CompressPDF > 0 {
+        log.Printf("compress-pdf: images %d -> %d bytes", c.inBytes, c.outBytes)
     }
-    file, err := os.Create(outputPath)
-    if err != nil {
+    if outputPath == "-" {
+        _, err := c.pdf.WriteTo(os.Stdout)
         return err
     }
-    defer func(file *os.File) {
-        err := file.Close()
-        if err != nil {
-            fmt.Println(err.Error())
-            os.Exit(1)
-        }
-    }(file)
-    _, err = c.buffer.WriteTo(file)
-    return err
+    return c.pdf.WritePdf(outputPath)
 }
 
-func getOzPageImgLinks(doc soup.Root) []string {
-    // regex find the documentURL, e.g:
-    // viewerOptions: {
-    //        // 필수항목
-    //        containerId: '#ozViewer',
-    //        documentURL: 'https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=2830&hashValue=2e0b924676bdc38241bd8fd452191fe3',
-    re := regexp.MustCompile("viewerOptions: \\{\n.*// 필수항목\n.*containerId: '#ozViewer',\n.*documentURL: '(.+)'")
-    matches := re.FindStringSubmatch(doc.HTML())
-    if len(matches) != 2 {
-        fmt.Println("could not find documentURL")
-        os.Exit(1)
-    }
+// PDFZipComicFile writes one pdf per episode as entries of a zip, pages
+// added before the first setEpisode, e.g. the -toc page, go to contents.pdf
+type PDFZipComicFile struct {
+    zipWriter *zip.Writer
+    file      *os.File
+    // pdf of the episode being added and its entry name
+    current   *PDFComicFile
+    name      string
+    notes     *PDFComicFile
+    source    func(c *PDFComicFile)
+}
 
-    // fetch json at documentURL and deserialize to MotiontoonJson
-    resp, err := soup.Get(matches[1])
+// validate PDFZipComicFile implements ComicFile
+var _ ComicFile = &PDFZipComicFile{}
+
+// newPDFZipComicFile streams the archive to a temp file in dir, renamed on save
+func newPDFZipComicFile(dir string) (*PDFZipComicFile, error) {
+    file, err := os.CreateTemp(dir, ".webtoon-dl-*.zip.tmp")
     if err != nil {
-        fmt.Println(fmt.Sprintf("Error fetching page: %v", err))
-        os.Exit(1)
+        return nil, err
     }
-    var motionToon MotiontoonJson
-    if err := json.Unmarshal([]byte(resp), &motionToon); err != nil {
-        fmt.Println(fmt.Sprintf("Error unmarshalling json: %v", err))
-        os.Exit(1)
+    return &PDFZipComicFile{zipWriter: zip.NewWriter(file), file: file}, nil
+}
+
+// setEpisode starts the pdf of epNo, the pdf of the previous episode is
+// written to the zip
+func (c *PDFZipComicFile) setEpisode(epNo int, minor string) error {
+    name := fmt.Sprintf("ep%s.pdf", episodeLabel(epNo, minor, 4))
+    if name == c.name && c.current != nil {
+        return nil
+    }
+    if err := c.flush(); err != nil {
+        return err
     }
+    c.name = name
+    return nil
+}
 
-    // get sorted keys
-    var sortedKeys []string
-    for k := range motionToon.Assets.Image {
-        sortedKeys = append(sortedKeys, k)
+// page returns the pdf pages are added to, created on the first page
+func (c *PDFZipComicFile) page() *PDFComicFile {
+    if c.current == nil {
+        c.current = c.newPDF()
+        if c.name == "" {
+            c.name = "contents.pdf"
+        }
     }
-    sort.Strings(sortedKeys)
+    return c.current
+}
 
-    // get path rule, e.g:
-    // motiontoonParam: {
-    //   pathRuleParam: {os.Exit
-    //     stillcut: 'https://ewebtoon-phinf.pstatic.net/motiontoon/3536_2e0b924676bdc38241bd8fd452191fe3/{=filename}?type=q70',
-    re = regexp.MustCompile("motiontoonParam: \\{\n.*pathRuleParam: \\{\n.*stillcut: '(.+)'")
-    matches = re.FindStringSubmatch(doc.HTML())
-    if len(matches) != 2 {
-        fmt.Println("could not find pathRule")
-        os.Exit(1)
+func (c *PDFZipComicFile) newPDF() *PDFComicFile {
+    pdf := newPDFComicFile()
+    if c.source != nil {
+        c.source(pdf)
     }
-    var imgs []string
-    for _, k := range sortedKeys {
-        imgs = append(imgs, strings.ReplaceAll(matches[1], "{=filename}", motionToon.Assets.Image[k]))
+    return pdf
+}
+
+// flush writes the pdf being added to the zip
+func (c *PDFZipComicFile) flush() error {
+    if c.current == nil {
+        return nil
     }
-    return imgs
+    pdf := c.current
+    c.current = nil
+    return c.writePDF(c.name, pdf)
 }
 
-func getImgLinksForEpisode(url string) []string {
-    resp, err := soup.Get(url)
-    time.Sleep(200 * time.Millisecond)
+func (c *PDFZipComicFile) writePDF(name string, pdf *PDFComicFile) error {
+    f, err := c.zipWriter.Create(name)
     if err != nil {
-        fmt.Println(fmt.Sprintf("Error fetching page: %v", err))
-        os.Exit(1)
-    }
-    doc := soup.HTMLParse(resp)
-    imgs := doc.Find("div", "class", "viewer_lst").FindAll("img")
-    if len(imgs) == 0 {
-        // some comics seem to serve images from a different backend, something about oz
-        return getOzPageImgLinks(doc)
-    }
-    var imgLinks []string
-    for _, img := range imgs {
-        if dataURL, ok := img.Attrs()["data-url"]; ok {
-            imgLinks = append(imgLinks, dataURL)
-        }
+        return err
     }
-    return imgLinks
+    _, err = pdf.pdf.WriteTo(f)
+    return err
 }
 
-func getEpisodeLinksForPage(url string) ([]EpisodeInfo, error) {
-    resp, err := soup.Get(url)
-    time.Sleep(200 * time.Millisecond)
+func (c *PDFZipComicFile) addImage(img []byte) error {
+    return c.page().addImage(img)
+}
+
+// addCover is stored as cover.jpg next to the episode pdfs
+func (c *PDFZipComicFile) addCover(img []byte) error {
+    f, err := c.zipWriter.Create("cover.jpg")
     if err != nil {
-        return []EpisodeInfo{}, fmt.Errorf("error fetching page: %v", err)
+        return err
     }
-    doc := soup.HTMLParse(resp)
-    episodeURLs := doc.Find("div", "class", "detail_lst").FindAll("a")
-    var episode []EpisodeInfo
-//    var title string
-    for _, episodeURL := range episodeURLs {
-        if href := episodeURL.Attrs()["href"]; strings.Contains(href, "/viewer") {
-            span:=episodeURL.Find("span","class","subj").Find("span")
+    _, err = f.Write(img)
+    return err
+}
 
-//            title=span.Text()
-            episode = append(episode, EpisodeInfo{
-                title:span.Text(),
-                url:href,
-            })
-        }
+// addText goes to notes.pdf, written on save
+func (c *PDFZipComicFile) addText(text string) error {
+    if c.notes == nil {
+        c.notes = c.newPDF()
     }
-    return episode, nil
+    return c.notes.addText(text)
 }
 
-func getEpisodeBatches(url string, minEp, maxEp, epsPerBatch int) ([]EpisodeBatch,error) {
-    if strings.Contains(url, "/viewer") {
-        // assume viewing single episode
-        return []EpisodeBatch{{
-            imgLinks: getImgLinksForEpisode(url),
-            minEp:    episodeNo(url),
-            maxEp:    episodeNo(url),
-        }},nil
-    } else {
-        // assume viewing set of episodes
-        log.Printf("scanning all pages to get all episode links")
-        allEpisodeLinks := getAllEpisodeLinks(url)
-        log.Printf("found %d total episodes", len(allEpisodeLinks))
-
-        var desiredEpisodeLinks []string
-        var desiredEpisodeTitles []string
-        for _, episodeLink := range allEpisodeLinks {
-
-            epNo := episodeNo(episodeLink.url)
+// setSource is set on every pdf of the zip
+func (c *PDFZipComicFile) setSource(firstURL string, lastURL string, notes string, downloaded time.Time) {
+    c.source = func(pdf *PDFComicFile) {
+        pdf.setSource(firstURL, lastURL, notes, downloaded)
+    }
+}
 
-            if epNo >= minEp && epNo <= maxEp {
-                desiredEpisodeLinks = append(desiredEpisodeLinks, episodeLink.url)
-                desiredEpisodeTitles = append(desiredEpisodeTitles,episodeLink.title)
-            }
-        }
+// abort drops the temp file of an archive that won't be saved
+func (c *PDFZipComicFile) abort() {
+    c.zipWriter.Close()
+    c.file.Close()
+    os.Remove(c.file.Name())
+}
 
-        if len(desiredEpisodeLinks) == 0{
-            return nil,errors.New("No episode found")
+func (c *PDFZipComicFile) save(outputPath string) error {
+    if err := c.flush(); err != nil {
+        return err
+    }
+    if c.notes != nil {
+        if err := c.writePDF("notes.pdf", c.notes); err != nil {
+            return err
         }
-        actualMinEp := episodeNo(desiredEpisodeLinks[0])
-        if minEp > actualMinEp {
-            actualMinEp = minEp
+    }
+    if err := c.zipWriter.Close(); err != nil {
+        return err
+    }
+    if err := c.file.Close(); err != nil {
+        return err
+    }
+    if outputPath == "-" {
+        defer os.Remove(c.file.Name())
+        file, err := os.Open(c.file.Name())
+        if err != nil {
+            return err
         }
+        defer file.Close()
+        _, err = io.Copy(os.Stdout, file)
+        return err
+    }
+    // temp files are created 0600
+    if err := os.Chmod(c.file.Name(), 0644); err != nil {
+        return err
+    }
+    return os.Rename(c.file.Name(), outputPath)
+}
 
-        actualMaxEp := episodeNo(desiredEpisodeLinks[len(desiredEpisodeLinks)-1])
-        if maxEp < actualMaxEp {
-            actualMaxEp = maxEp
-        }
-        log.Printf("fetching image links for episodes %d through %d", actualMinEp, actualMaxEp)
+type CBZComicFile struct {
+    zipWriter *zip.Writer
+    file      *os.File
+    numFiles  int
+    // ComicInfo.xml page entries, one per image in archive order
+    pages     []ComicPageInfo
+    // entry name of the next image, set with setPage for -cbz-episode-names
+    // or setName for -cdn-names
+    nextName  string
+    names     entryNames
+    // written to ComicInfo.xml, see -provenance
+    web       string
+    notes     string
+}
 
-        var episodeBatches []EpisodeBatch
-        for start := 0; start < len(desiredEpisodeLinks); start += epsPerBatch {
-            end := start + epsPerBatch
-            if end > len(desiredEpisodeLinks) {
-                end = len(desiredEpisodeLinks)
-            }
-            episodeBatches = append(episodeBatches, EpisodeBatch{
-                imgLinks: getImgLinksForEpisodes(desiredEpisodeLinks[start:end], actualMaxEp),
-                title:    createTitle(desiredEpisodeTitles[start:end]),
-                minEp:    episodeNo(desiredEpisodeLinks[start]),
-                maxEp:    episodeNo(desiredEpisodeLinks[end-1]),
-            })
-        }
+type ComicInfo struct {
+    XMLName xml.Name        `xml:"ComicInfo"`
+    // in the ComicInfo schema order
+    Notes   string          `xml:"Notes,omitempty"`
+    Web     string          `xml:"Web,omitempty"`
+    Pages   []ComicPageInfo `xml:"Pages>Page"`
+}
 
-        return episodeBatches, nil
-    }
+type ComicPageInfo struct {
+    Image int    `xml:"Image,attr"`
+    Type  string `xml:"Type,attr"`
 }
 
-func createTitle(episodetitles []string) string{
-    var title string
+// validate CBZComicFile implements ComicFile
+var _ ComicFile = &CBZComicFile{}
 
-    for _, episodeTitle := range episodetitles {
-        title = title+episodeTitle+"_"
+// newCBZComicFile streams the archive to a temp file in dir, renamed on save
+func newCBZComicFile(dir string) (*CBZComicFile, error) {
+    file, err := os.CreateTemp(dir, ".webtoon-dl-*.cbz.tmp")
+    if err != nil {
+        return nil, err
     }
-    last:=len(title)-1
-
-    return title[:last]
+    zipWriter := zip.NewWriter(file)
+    return &CBZComicFile{zipWriter: zipWriter, file: file, numFiles: 0, names: make(entryNames)}, nil
 }
-func getAllEpisodeLinks(url string) []EpisodeInfo {
-    re := regexp.MustCompile("&page=[0-9]+")
-    episodeSet := make(map[EpisodeInfo]struct{})
-//    episodeTitleSet := make(map[string]struct{})
-    foundLastPage := false
-    for page := 1; !foundLastPage; page++ {
-        url = re.ReplaceAllString(url, "") + fmt.Sprintf("&page=%d", page)
-        episodes, err := getEpisodeLinksForPage(url)
-
-        if err != nil {
-            break
-        }
-        for _, episode := range episodes {
-            // when you go past the last page, it just rerenders the last page
-            if _, ok := episodeSet[episode]; ok {
-                foundLastPage = true
-                break
-            }
-            episodeSet[episode] = struct{}{}
-        }
 
-        if !foundLastPage {
-            log.Printf(url)
-        }
+func (c *CBZComicFile) addImage(img []byte) error {
+    return c.addPage(img, "Story")
+}
 
-    }
+// addCover tags the page as FrontCover so Komga/Kavita use it as thumbnail
+func (c *CBZComicFile) addCover(img []byte) error {
+    return c.addPage(img, "FrontCover")
+}
 
-    allEpisode := make([]EpisodeInfo, 0, len(episodeSet))
-    for episode := range episodeSet {
-        allEpisode = append(allEpisode, episode)
+// setSource is written to the Web and Notes fields of ComicInfo.xml, Web
+// takes several urls separated by a space
+func (c *CBZComicFile) setSource(firstURL string, lastURL string, notes string, downloaded time.Time) {
+    c.web = firstURL
+    if lastURL != firstURL {
+        c.web += " " + lastURL
     }
-    // extract episode_no from url and sort by it
-    sort.Slice(allEpisode, func(i, j int) bool {
-        return episodeNo(allEpisode[i].url) < episodeNo(allEpisode[j].url)
-    })
-    return allEpisode
+    c.notes = notes
 }
 
-func episodeNo(episodeLink string) int {
-//    log.Printf("%s",episodeLink)
-    re := regexp.MustCompile("episode_no=([0-9]+)")
-    matches := re.FindStringSubmatch(episodeLink)
-    if len(matches) != 2 {
-        log.Printf("episodeNo not found %d",len(matches))
-        return 0
-    }
+// setPage names the next image entry after its episode and page
+func (c *CBZComicFile) setPage(epNo int, minor string, page int) {
+    c.nextName = fmt.Sprintf("ep%s_p%04d.jpg", episodeLabel(epNo, minor, 4), page)
+}
 
-    episodeNo, err := strconv.Atoi(matches[1])
+func (c *CBZComicFile) setName(name string) {
+    c.nextName = c.names.unique(name)
+}
 
+func (c *CBZComicFile) addPage(img []byte, pageType string) error {
+    name := fmt.Sprintf("%010d.jpg", c.numFiles)
+    if c.nextName != "" {
+        name = c.nextName
+        c.nextName = ""
+    }
+    f, err := c.zipWriter.Create(name)
     if err != nil {
-        log.Printf("episodeNo %d",matches[1])
-        return 0
+        return err
     }
-    return episodeNo
-}
-
-func getImgLinksForEpisodes(episodeLinks []string, actualMaxEp int) []string {
-    var allImgLinks []string
-    for _, episodeLink := range episodeLinks {
-        log.Printf("fetching image links for episode %d/%d", episodeNo(episodeLink), actualMaxEp)
-        allImgLinks = append(allImgLinks, getImgLinksForEpisode(episodeLink)...)
+    _, err = f.Write(img)
+    if err != nil {
+        return err
     }
-    return allImgLinks
+    c.pages = append(c.pages, ComicPageInfo{Image: len(c.pages), Type: pageType})
+    c.numFiles++
+    return nil
 }
 
-func fetchImage(imgLink string) []byte {
-    req, e := http.NewRequest("GET", imgLink, nil)
-    if e != nil {
-        fmt.Println(e)
-        os.Exit(1)
+func (c *CBZComicFile) addText(text string) error {
+    name := fmt.Sprintf("%010d.txt", c.numFiles)
+    if *CBZEpisodeNames {
+        // keep the notes after the ep entries
+        name = "notes_" + name
     }
-    req.Header.Set("Referer", "http://www.webtoons.com")
-
-    response, err := http.DefaultClient.Do(req)
+    f, err := c.zipWriter.Create(name)
     if err != nil {
-        fmt.Println(err.Error())
-        os.Exit(1)
+        return err
     }
-    defer func(Body io.ReadCloser) {
-        err := Body.Close()
+    _, err = f.Write([]byte(text))
+    if err != nil {
+        return err
+    }
+    c.numFiles++
+    return nil
+}
+
+// addCBZPages copies the pages of an existing cbz, its FrontCover pages
+// are kept as cover only when keepCover is set
+func (c *CBZComicFile) addCBZPages(sourceFile string, keepCover bool) error {
+    r, err := zip.OpenReader(sourceFile)
+    if err != nil {
+        return err
+    }
+    defer r.Close()
+
+    readEntry := func(f *zip.File) ([]byte, error) {
+        rc, err := f.Open()
         if err != nil {
-            fmt.Println(err.Error())
-            os.Exit(1)
+            return nil, err
         }
-    }(response.Body)
+        defer rc.Close()
+        return io.ReadAll(rc)
+    }
 
-    buff := new(bytes.Buffer)
-    _, err = buff.ReadFrom(response.Body)
-    if err != nil {
-        fmt.Println(err.Error())
-        os.Exit(1)
+    covers := make(map[int]bool)
+    for _, f := range r.File {
+        if f.Name != "ComicInfo.xml" {
+            continue
+        }
+        data, err := readEntry(f)
+        if err != nil {
+            return err
+        }
+        var info ComicInfo
+        if err := xml.Unmarshal(data, &info); err != nil {
+            return err
+        }
+        for _, page := range info.Pages {
+            if page.Type == "FrontCover" {
+                covers[page.Image] = true
+            }
+        }
+    }
+
+    imageNo := 0
+    for _, f := range r.File {
+        if f.Name == "ComicInfo.xml" {
+            continue
+        }
+        data, err := readEntry(f)
+        if err != nil {
+            return err
+        }
+        if strings.HasSuffix(f.Name, ".txt") {
+            err = c.addText(string(data))
+        } else if covers[imageNo] {
+            if keepCover {
+                err = c.addCover(data)
+            }
+            imageNo++
+        } else {
+            err = c.addImage(data)
+            imageNo++
+        }
+        if err != nil {
+            return err
+        }
     }
-    return buff.Bytes()
+    return nil
 }
 
-func getComicFile(format string) ComicFile {
-    var comic ComicFile
-    var err error
-    comic = newPDFComicFile()
-    if format == "cbz" {
-        comic, err = newCBZComicFile()
+// abort drops the temp file of an archive that won't be saved
+func (c *CBZComicFile) abort() {
+    c.zipWriter.Close()
+    c.file.Close()
+    os.Remove(c.file.Name())
+}
+
+func (c *CBZComicFile) save(outputPath string) error {
+    f, err := c.zipWriter.Create("ComicInfo.xml")
+    if err != nil {
+        return err
+    }
+    info, err := xml.MarshalIndent(ComicInfo{Notes: c.notes, Web: c.web, Pages: c.pages}, "", "  ")
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(append([]byte(xml.Header), info...)); err != nil {
+        return err
+    }
+    if err := c.zipWriter.Close(); err != nil {
+        return err
+    }
+    if err := c.file.Close(); err != nil {
+        return err
+    }
+    if outputPath == "-" {
+        defer os.Remove(c.file.Name())
+        file, err := os.Open(c.file.Name())
         if err != nil {
-            fmt.Println(err.Error())
-            os.Exit(1)
+            return err
         }
+        defer file.Close()
+        _, err = io.Copy(os.Stdout, file)
+        return err
     }
-    return comic
+    // temp files are created 0600
+    if err := os.Chmod(c.file.Name(), 0644); err != nil {
+        return err
+    }
+    return os.Rename(c.file.Name(), outputPath)
 }
 
-type Opts struct {
-    url        string
-    minEp      int
-    maxEp      int
-    epsPerFile int
-    format     string
+// DirComicFile writes the pages as numbered image files in a folder
+type DirComicFile struct {
+    dir      string
+    numFiles int
+    // file name of the next image with -cdn-names
+    nextName string
+    names    entryNames
+}
 
+// validate DirComicFile implements ComicFile
+var _ ComicFile = &DirComicFile{}
+
+// newDirComicFile writes to a temp folder in dir, renamed on save
+func newDirComicFile(dir string) (*DirComicFile, error) {
+    tmp, err := os.MkdirTemp(dir, ".webtoon-dl-*.tmp")
+    if err != nil {
+        return nil, err
+    }
+    return &DirComicFile{dir: tmp, names: make(entryNames)}, nil
 }
 
-func parseOpts(args []string) Opts {
+func (c *DirComicFile) addImage(img []byte) error {
+    return c.addFile(img, "jpg")
+}
 
-    if len(args) < 2 {
-        fmt.Println("Usage: webtoon-dl <url>")
-        os.Exit(1)
+// setSource is a no-op, a folder of images has nowhere to keep it
+func (c *DirComicFile) setSource(firstURL string, lastURL string, notes string, downloaded time.Time) {}
+
+func (c *DirComicFile) addCover(img []byte) error {
+    return c.addImage(img)
+}
+
+func (c *DirComicFile) addText(text string) error {
+    return c.addFile([]byte(text), "txt")
+}
+
+func (c *DirComicFile) setName(name string) {
+    c.nextName = c.names.unique(name)
+}
+
+func (c *DirComicFile) addFile(data []byte, ext string) error {
+    name := c.nextPath(ext)
+    if c.nextName != "" && ext != "txt" {
+        name = filepath.Join(c.dir, c.nextName)
+        c.nextName = ""
+    }
+    err := os.WriteFile(name, data, 0644)
+    if err != nil {
+        return err
     }
+    c.numFiles++
+    return nil
+}
+
+func (c *DirComicFile) nextPath(ext string) string {
+    return filepath.Join(c.dir, fmt.Sprintf("%03d.%s", c.numFiles+1, ext))
+}
 
-    database = flag.Bool("db", false, "Database mode get url from sqlfile for multiple webtoon")
-    confOverride = flag.Bool("confOverride", false, "Override database config by parameter store in database")
-    FileVerify = flag.Bool("file", false, "Check file if exist instead of recreate it dirrectly")
+// addImageLink fetches imgLink straight to its page file, false when it
+// was a placeholder and got dropped, a skippedImage error when it wasn't
+// fetched
+func (c *DirComicFile) addImageLink(imgLink string, logger *log.Logger) (bool, error) {
+    path := c.nextPath("jpg")
+    if *CDNNames {
+        if name := cdnName(imgLink, nil); name != "" {
+            path = filepath.Join(c.dir, c.names.unique(name))
+        }
+    }
+    if err := fetchImageToFile(imgLink, path, logger); err != nil {
+        os.Remove(path)
+        return false, err
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        return false, err
+    }
+    placeholder := isPlaceholder(f)
+    f.Close()
+    if placeholder {
+        return false, os.Remove(path)
+    }
+    if err := prepareImageFile(path); err != nil {
+        return false, fmt.Errorf("%w: %v", errUndecodable, err)
+    }
+    if *CDNNames {
+        if err := c.fixExt(path); err != nil {
+            return false, err
+        }
+    }
+    c.numFiles++
+    return true, nil
+}
 
-    NoLog = flag.Bool("NoLog", false, "print output")
+// fixExt renames a -cdn-names image transcoded to jpeg by prepareImageFile
+func (c *DirComicFile) fixExt(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    name := cdnName(filepath.Base(path), f)
+    f.Close()
+    if name == filepath.Base(path) || name == "" {
+        return nil
+    }
+    return os.Rename(path, filepath.Join(c.dir, c.names.unique(name)))
+}
 
-    EpisodeGoroutine = flag.Int("E", 10, "Number of episode per webtoon download in the same time")
-    WebtoonGoroutine = flag.Int("W", 3, "Numer of webtoon download in the same time")
-    MaxWebtoonGoroutine= flag.Bool("MW", false, "Treat all webtoon at once")
+// abort drops the temp folder of pages that won't be saved
+func (c *DirComicFile) abort() {
+    os.RemoveAll(c.dir)
+}
 
-    minEp := flag.Int("min-ep", 0, "Minimum episode number to download (inclusive)")
-    maxEp := flag.Int("max-ep", math.MaxInt, "Maximum episode number to download (inclusive)")
+func (c *DirComicFile) save(outputPath string) error {
+    // temp folders are created 0700
+    if err := os.Chmod(c.dir, 0755); err != nil {
+        return err
+    }
+    // with -force the previous folder is replaced
+    if err := os.RemoveAll(outputPath); err != nil {
+        return err
+    }
+    return os.Rename(c.dir, outputPath)
+}
 
-    epsPerFile := flag.Int("eps-per-file", 1, "Number of episodes to put in each PDF file")
-    format := flag.String("format", "pdf", "Output format (pdf or cbz)")
-    flag.Parse()
+var trailingNumberRe = regexp.MustCompile(`^(.*?)([0-9]+)$`)
 
-    if *minEp > *maxEp {
-        fmt.Println("min-ep must be less than or equal to max-ep")
-        os.Exit(1)
+// naturalLess orders keys ending in a number by that number, 2 before 10,
+// other keys lexicographically
+func naturalLess(a, b string) bool {
+    ma := trailingNumberRe.FindStringSubmatch(a)
+    mb := trailingNumberRe.FindStringSubmatch(b)
+    if ma != nil && mb != nil && ma[1] == mb[1] {
+        na, errA := strconv.Atoi(ma[2])
+        nb, errB := strconv.Atoi(mb[2])
+        if errA == nil && errB == nil && na != nb {
+            return na < nb
+        }
     }
-    if *epsPerFile < 1 {
-        fmt.Println("eps-per-file must be greater than or equal to 1")
-        os.Exit(1)
+    return a < b
+}
+
+var ozDocumentURLRe = regexp.MustCompile("viewerOptions: \\{\n.*// 필수항목\n.*containerId: '#ozViewer',\n.*documentURL: '(.+)'")
+var ozPathRuleRe = regexp.MustCompile("motiontoonParam: \\{\n.*pathRuleParam: \\{\n.*stillcut: '(.+)'")
+
+func getOzPageImgLinks(doc soup.Root) ([]string, error) {
+    // regex find the documentURL, e.g:
+    // viewerOptions: {
+    //        // 필수항목
+    //        containerId: '#ozViewer',
+    //        documentURL: 'https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=2830&hashValue=2e0b924676bdc38241bd8fd452191fe3',
+    matches := ozDocumentURLRe.FindStringSubmatch(doc.HTML())
+    if len(matches) != 2 {
+        return nil, errors.New("could not find documentURL")
     }
-    if *minEp < 0 {
-        fmt.Println("min-ep must be greater than or equal to 0")
-        os.Exit(1)
+
+    // fetch json at documentURL and deserialize to MotiontoonJson
+    resp, err := getPage(matches[1])
+    if err != nil {
+        return nil, fmt.Errorf("error fetching page: %w", err)
+    }
+    var motionToon MotiontoonJson
+    if err := json.Unmarshal([]byte(resp), &motionToon); err != nil {
+        return nil, fmt.Errorf("error unmarshalling json: %v", err)
     }
 
-    url := os.Args[len(os.Args)-1]
-    return Opts{
-        url:        url,
-        minEp:      *minEp,
-        maxEp:      *maxEp,
-        epsPerFile: *epsPerFile,
-        format:     *format,
+    // get sorted keys
+    var sortedKeys []string
+    for k := range motionToon.Assets.Image {
+        sortedKeys = append(sortedKeys, k)
+    }
+    sort.Slice(sortedKeys, func(i, j int) bool {
+        return naturalLess(sortedKeys[i], sortedKeys[j])
+    })
+
+    // get path rule, e.g:
+    // motiontoonParam: {
+    //   pathRuleParam: {os.Exit
+    //     stillcut: 'https://ewebtoon-phinf.pstatic.net/motiontoon/3536_2e0b924676bdc38241bd8fd452191fe3/{=filename}?type=q70',
+    matches = ozPathRuleRe.FindStringSubmatch(doc.HTML())
+    if len(matches) != 2 {
+        return nil, errors.New("could not find pathRule")
+    }
+    var imgs []string
+    for _, k := range sortedKeys {
+        imgs = append(imgs, withImageQuality(strings.ReplaceAll(matches[1], "{=filename}", motionToon.Assets.Image[k])))
     }
+    return imgs, nil
 }
 
-func getOutFile(opts Opts, episodeBatch EpisodeBatch) string {
-    outURL := strings.ReplaceAll(opts.url, "http://", "")
-    outURL = strings.ReplaceAll(outURL, "https://", "")
-    outURL = strings.ReplaceAll(outURL, "www.", "")
-    outURL = strings.ReplaceAll(outURL, "webtoons.com/", "")
-    outURL = strings.Split(outURL, "?")[0]
-    outURL = strings.ReplaceAll(outURL, "/viewer", "")
-    outURL = strings.ReplaceAll(outURL, "/", "-")
-    if episodeBatch.minEp != episodeBatch.maxEp {
-        outURL = fmt.Sprintf("%s-epNo%d-epNo%d.%s", outURL, episodeBatch.minEp, episodeBatch.maxEp, opts.format)
-    } else {
-        outURL = fmt.Sprintf("%s-epNo%d.%s", outURL, episodeBatch.minEp, opts.format)
+// values of -image-quality besides original, the type= param of oz images
+var imageQualityRe = regexp.MustCompile("^q[0-9]+$")
+
+// withImageQuality rewrites the type= query param of an oz image link, e.g.
+// ?type=q70 becomes ?type=q90, or is dropped entirely for the original image.
+// The rest of the link is kept as it is, signed links break when their query
+// is reordered or escaped again
+func withImageQuality(imgLink string) string {
+    link, fragment, hasFragment := strings.Cut(imgLink, "#")
+    base, query, _ := strings.Cut(link, "?")
+    var params []string
+    found := false
+    if query != "" {
+        for _, param := range strings.Split(query, "&") {
+            if name, _, _ := strings.Cut(param, "="); name != "type" {
+                params = append(params, param)
+                continue
+            }
+            if !found && *ImageQuality != "original" {
+                params = append(params, "type="+*ImageQuality)
+            }
+            found = true
+        }
+    }
+    if !found {
+        if *ImageQuality == "original" {
+            return imgLink
+        }
+        params = append(params, "type="+*ImageQuality)
     }
+    link = base
+    if len(params) > 0 {
+        link += "?" + strings.Join(params, "&")
+    }
+    if hasFragment {
+        link += "#" + fragment
+    }
+    return link
+}
 
-    return outURL
+// runs of characters replaced in -save-html file names
+var htmlDumpRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
 
+// saveHTML keeps a copy of a scraped page in the -save-html folder, named
+// after its url, to attach to bug reports
+func saveHTML(url string, page string) {
+    if *SaveHTML == "" {
+        return
+    }
+    name := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+    name = strings.Trim(htmlDumpRe.ReplaceAllString(name, "_"), "_")
+    if len(name) > 200 {
+        name = name[:200]
+    }
+    err := os.WriteFile(filepath.Join(*SaveHTML, name+".html"), []byte(page), 0644)
+    if err != nil {
+        log.Printf("WARNING: could not save html of %s: %v", url, err)
+    }
 }
 
-func getWebtoonTitle(opts Opts) (string,string,error) {
+// getImgLinksForEpisode returns the image links, author note and title of
+// an episode
+func getImgLinksForEpisode(url string) ([]string, string, string, error) {
+    resp, err := getPage(url)
+    time.Sleep(200 * time.Millisecond)
+    if err != nil {
+        return nil, "", "", fmt.Errorf("error fetching page: %w", err)
+    }
+    saveHTML(url, resp)
+    doc := soup.HTMLParse(resp)
+    imgLinks, _, err := parseImgLinks(doc)
+    if err != nil {
+        return nil, "", "", err
+    }
+    var note string
+    if *IncludeNotes {
+        note = parseAuthorNote(doc)
+    }
+    return imgLinks, note, parseEpisodeTitle(doc), nil
+}
 
-    _, err :=url.ParseRequestURI(opts.url)
+// parseEpisodeTitle reads the episode title of a viewer page, same as the
+// subject shown in the episode list. The parser already decodes entities
+func parseEpisodeTitle(doc soup.Root) string {
+    if subj := doc.Find("h1", "class", "subj_episode"); subj.Error == nil {
+        if title := strings.TrimSpace(subj.Attrs()["title"]); title != "" {
+            return title
+        }
+        if title := strings.TrimSpace(subj.FullText()); title != "" {
+            return title
+        }
+    }
+    for _, meta := range doc.FindAll("meta") {
+        if meta.Attrs()["property"] == "og:title" {
+            return strings.TrimSpace(meta.Attrs()["content"])
+        }
+    }
+    return ""
+}
+
+func parseAuthorNote(doc soup.Root) string {
+    note := doc.Find("p", "class", "author_text")
+    if note.Error != nil {
+        return ""
+    }
+    return strings.TrimSpace(note.FullText())
+}
+
+// parseImgLinks extracts the image links of an episode page, reporting whether
+// they came from the oz/motiontoon backend
+func parseImgLinks(doc soup.Root) ([]string, bool, error) {
+    if doc.Error != nil {
+        return nil, false, doc.Error
+    }
+    // interstitials can split an episode across several viewer_lst, keep
+    // their images in page order and each once if the containers nest
+    var imgs []soup.Root
+    seen := make(map[*nethtml.Node]bool)
+    for _, viewer := range doc.FindAll("div", "class", "viewer_lst") {
+        for _, img := range viewer.FindAll("img") {
+            if !seen[img.Pointer] {
+                seen[img.Pointer] = true
+                imgs = append(imgs, img)
+            }
+        }
+    }
+    if len(imgs) == 0 {
+        // some comics seem to serve images from a different backend, something about oz
+        if !strings.Contains(doc.HTML(), "ozViewer") {
+            return nil, false, errors.New("found neither viewer_lst images nor oz viewer")
+        }
+        imgLinks, err := getOzPageImgLinks(doc)
+        return imgLinks, true, err
+    }
+    var imgLinks []string
+    for _, img := range imgs {
+        if imgLink := imgSource(img); imgLink != "" {
+            imgLinks = append(imgLinks, imgLink)
+        }
+    }
+    return imgLinks, false, nil
+}
+
+// transparent pixels and loading spinners lazy-loaded images keep in src
+// until their real url is swapped in
+var placeholderSrcRe = regexp.MustCompile(`(?i)(bg_transparency|transparent|spinner|loading|placeholder|blank)[^/]*\.(gif|png|svg)`)
+
+// imgSource is the real url of a viewer image, data-url then data-src then
+// src, skipping inline data and placeholder images, "" when there is none
+func imgSource(img soup.Root) string {
+    attrs := img.Attrs()
+    for _, attr := range []string{"data-url", "data-src", "src"} {
+        src := strings.TrimSpace(attrs[attr])
+        if src == "" || strings.HasPrefix(src, "data:") || placeholderSrcRe.MatchString(src) {
+            continue
+        }
+        return src
+    }
+    return ""
+}
+
+var listPageRe = regexp.MustCompile("[?&]page=([0-9]+)")
+
+// lastListPage is the highest page number linked from the pagination of a
+// list page, 0 without pagination
+func lastListPage(doc soup.Root) int {
+    paginate := doc.Find("div", "class", "paginate")
+    if paginate.Error != nil {
+        return 0
+    }
+    last := 0
+    for _, link := range paginate.FindAll("a") {
+        matches := listPageRe.FindStringSubmatch(link.Attrs()["href"])
+        if len(matches) != 2 {
+            continue
+        }
+        if page, err := strconv.Atoi(matches[1]); err == nil && page > last {
+            last = page
+        }
+    }
+    return last
+}
+
+// getEpisodeLinksForPage also returns the last page number the page links to
+func getEpisodeLinksForPage(ctx context.Context, url string, logger *log.Logger) ([]EpisodeInfo, int, error) {
+    resp, err := getPageContext(ctx, url)
+    time.Sleep(200 * time.Millisecond)
     if err != nil {
-            return "","",err
+        return []EpisodeInfo{}, 0, fmt.Errorf("error fetching page: %w", err)
+    }
+    saveHTML(url, resp)
+    return parseEpisodeLinks(soup.HTMLParse(resp), logger)
+}
 
+// parseEpisodeLinks reads the episodes of a list page and its last page
+// number
+func parseEpisodeLinks(doc soup.Root, logger *log.Logger) ([]EpisodeInfo, int, error) {
+    // past the last page the site rerenders the last one, a page without
+    // the list at all is an error page, not the end of the series
+    list := doc.Find("div", "class", "detail_lst")
+    if list.Error != nil {
+        return []EpisodeInfo{}, 0, errors.New("no episode list on page")
     }
+    episodeURLs := list.FindAll("a")
+    var episode []EpisodeInfo
+//    var title string
+    for _, episodeURL := range episodeURLs {
+        if href := episodeURL.Attrs()["href"]; strings.Contains(href, "/viewer") {
+            subj := episodeURL.Find("span","class","subj")
+            if subj.Error != nil {
+                logger.Printf("WARNING: skipping episode without title: %s", href)
+                continue
+            }
+            // older layouts put the title directly in span.subj
+            span := subj.Find("span")
+            if span.Error != nil {
+                span = subj
+            }
 
-    outURL := strings.ReplaceAll(opts.url, "http://", "")
-    outURL = strings.ReplaceAll(outURL, "https://", "")
-    outURL = strings.ReplaceAll(outURL, "www.", "")
-    outURL = strings.ReplaceAll(outURL, "webtoons.com/", "")
-    lang := strings.Split(outURL, "/")[0]
-    title := strings.Split(outURL, "/")[2]
-    return title, lang, nil
+            // the parser decodes entities, a double escaped &amp;#39; is
+            // kept as the &#39; the site shows
+            title := strings.TrimSpace(span.FullText())
+            if title == "" {
+                logger.Printf("WARNING: skipping episode without title: %s", href)
+                continue
+            }
+            episode = append(episode, EpisodeInfo{
+                title:title,
+                url:href,
+                season:seasonNo(title),
+            })
+        }
+    }
+    return episode, lastListPage(doc), nil
+}
+
+// season labels found in titles, e.g. "[Season 2] Ep. 5", "(S2) Episode 5"
+// or "Saison 2 - Episode 5"
+var seasonRe = regexp.MustCompile(`(?i)(?:\bseason\s*|\bsaison\s*|[\[(]s)(\d+)`)
+
+func seasonNo(title string) int {
+    matches := seasonRe.FindStringSubmatch(title)
+    if len(matches) != 2 {
+        return 0
+    }
+    season, err := strconv.Atoi(matches[1])
+    if err != nil {
+        return 0
+    }
+    return season
+}
+
+var errNoEpisode = errors.New("No episode found")
+
+// getEpisodeBatches also returns the latest episode_no listed for the
+// series, 0 for a single episode url. latestOnly > 0 keeps only that many of
+// the newest episodes in range
+func getEpisodeBatches(url string, minEp, maxEp, epsPerBatch, latestOnly int, cacheFile string, logger *log.Logger) ([]EpisodeBatch, int, error) {
+    if strings.Contains(url, "/viewer") {
+        // assume viewing single episode
+        imgLinks, note, title, err := getImgLinksForEpisode(url)
+        if err != nil {
+            return nil, 0, err
+        }
+        if title == "" {
+            title = fmt.Sprintf("Episode %d", episodeNo(url))
+        }
+        return []EpisodeBatch{{
+            imgLinks:   imgLinks,
+            title:      createTitle([]string{title}),
+            notes:      []string{note},
+            episodeNos: []int{episodeNo(url)},
+            minors:     []string{minorOf(url)},
+            titles:     []string{title},
+            pageCounts: []int{len(imgLinks)},
+            links:      []string{url},
+            minEp:      episodeNo(url),
+            maxEp:      episodeNo(url),
+        }}, 0, nil
+    } else {
+        // assume viewing set of episodes
+        logger.Printf("scanning all pages to get all episode links")
+        ctx := runCtx
+        if *ListTimeout > 0 {
+            var cancel context.CancelFunc
+            ctx, cancel = context.WithTimeout(ctx, *ListTimeout)
+            defer cancel()
+        }
+        allEpisodeLinks, err := getAllEpisodeLinks(ctx, url, cacheFile, logger)
+        if err != nil {
+            return nil, 0, err
+        }
+        logger.Printf("found %d total episodes", len(allEpisodeLinks))
+
+        filterSeason := false
+        if *Season > 0 {
+            for _, episodeLink := range allEpisodeLinks {
+                if episodeLink.season != 0 {
+                    filterSeason = true
+                    break
+                }
+            }
+            if !filterSeason {
+                logger.Printf("WARNING: no season labels found, using episode_no only")
+            }
+        }
+
+        var desiredEpisodeLinks []string
+        var desiredEpisodeTitles []string
+        for _, episodeLink := range allEpisodeLinks {
+
+            epNo := episodeNo(episodeLink.url)
+
+            if filterSeason && episodeLink.season != *Season {
+                continue
+            }
+            if epNo >= minEp && epNo <= maxEp {
+                desiredEpisodeLinks = append(desiredEpisodeLinks, episodeLink.url)
+                desiredEpisodeTitles = append(desiredEpisodeTitles,episodeLink.title)
+            }
+        }
+
+        if latestOnly > 0 && len(desiredEpisodeLinks) > latestOnly {
+            logger.Printf("keeping the latest %d of %d episodes", latestOnly, len(desiredEpisodeLinks))
+            desiredEpisodeLinks = desiredEpisodeLinks[len(desiredEpisodeLinks)-latestOnly:]
+            desiredEpisodeTitles = desiredEpisodeTitles[len(desiredEpisodeTitles)-latestOnly:]
+        }
+
+        latest := 0
+        if len(allEpisodeLinks) > 0 {
+            latest = episodeNo(allEpisodeLinks[len(allEpisodeLinks)-1].url)
+        }
+        if len(desiredEpisodeLinks) == 0{
+            return nil, latest, errNoEpisode
+        }
+        actualMinEp := episodeNo(desiredEpisodeLinks[0])
+        if minEp > actualMinEp {
+            actualMinEp = minEp
+        }
+
+        actualMaxEp := episodeNo(desiredEpisodeLinks[len(desiredEpisodeLinks)-1])
+        if maxEp < actualMaxEp {
+            actualMaxEp = maxEp
+        }
+        if *ResumeFrom > 0 {
+            logger.Printf("resuming from %d to latest %d", *ResumeFrom, episodeNo(allEpisodeLinks[len(allEpisodeLinks)-1].url))
+        }
+        logger.Printf("fetching image links for episodes %d through %d", actualMinEp, actualMaxEp)
+
+        if epsPerBatch == 0 {
+            // single batch spanning the whole range
+            epsPerBatch = len(desiredEpisodeLinks)
+        }
+        var episodeBatches []EpisodeBatch
+        for start := 0; start < len(desiredEpisodeLinks); start += epsPerBatch {
+            end := start + epsPerBatch
+            if end > len(desiredEpisodeLinks) {
+                end = len(desiredEpisodeLinks)
+            }
+            episodeBatch := getImgLinksForEpisodes(desiredEpisodeLinks[start:end], desiredEpisodeTitles[start:end], actualMaxEp, logger)
+            episodeBatch.title = createTitle(desiredEpisodeTitles[start:end])
+            episodeBatch.minEp = episodeNo(desiredEpisodeLinks[start])
+            episodeBatch.maxEp = episodeNo(desiredEpisodeLinks[end-1])
+            episodeBatches = append(episodeBatches, episodeBatch)
+        }
+
+        return episodeBatches, latest, nil
+    }
+}
+
+// entryNames hands out unique file names, suffixing repeated ones
+type entryNames map[string]bool
+
+func (names entryNames) unique(name string) string {
+    ext := filepath.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    candidate := name
+    for i := 2; names[candidate]; i++ {
+        candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+    }
+    names[candidate] = true
+    return candidate
+}
+
+// cdnName is the file name of imgLink on the CDN for -cdn-names, empty when
+// its path has none; given the image, a name is switched to .jpg when the
+// image was transcoded to jpeg
+func cdnName(imgLink string, img io.Reader) string {
+    u, err := url.Parse(imgLink)
+    if err != nil {
+        return ""
+    }
+    name := sanitizeFilename(path.Base(u.Path))
+    ext := strings.ToLower(filepath.Ext(name))
+    if ext == "" || name == ext {
+        return ""
+    }
+    if img != nil && ext != ".jpg" && ext != ".jpeg" {
+        if _, format, err := image.DecodeConfig(img); err == nil && format == "jpeg" {
+            name = strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+        }
+    }
+    return name
+}
+
+// characters that are not allowed in file names on common file systems
+var unsafeFilenameRe = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+
+func sanitizeFilename(name string) string {
+    return strings.Trim(unsafeFilenameRe.ReplaceAllString(name, "_"), " .")
+}
+
+func createTitle(episodetitles []string) string{
+    var title string
+
+    names := make([]string, len(episodetitles))
+    for i, episodeTitle := range episodetitles {
+        names[i] = sanitizeFilename(episodeTitle)
+        title = title+names[i]+"_"
+    }
+    last:=len(title)-1
+
+    // keep file names under the usual 255 bytes limit for large batches
+    if last > 200 && len(names) > 1 {
+        return names[0]+"_to_"+names[len(names)-1]
+    }
+    return title[:last]
+}
+const listPageAttempts = 3
+
+// getListPage retries transient failures so a single blip doesn't cut the
+// series short
+func getListPage(ctx context.Context, url string, logger *log.Logger) ([]EpisodeInfo, int, error) {
+    var episodes []EpisodeInfo
+    var last int
+    var err error
+    for attempt := 1; attempt <= listPageAttempts; attempt++ {
+        episodes, last, err = getEpisodeLinksForPage(ctx, url, logger)
+        if err == nil || ctx.Err() != nil || errors.Is(err, errAuthExpired) || errors.Is(err, errGone) {
+            return episodes, last, err
+        }
+        logger.Printf("attempt %d/%d for %s failed: %v", attempt, listPageAttempts, url, err)
+        select {
+        case <-time.After(time.Duration(attempt) * time.Second):
+        case <-ctx.Done():
+            return nil, 0, ctx.Err()
+        }
+    }
+    return nil, 0, err
+}
+
+type listPageResult struct {
+    episodes []EpisodeInfo
+    last     int
+    err      error
+}
+
+// getListPages fetches pages first to last with at most -list-workers at
+// the same time, results are in page order
+func getListPages(ctx context.Context, url string, first int, last int, logger *log.Logger) []listPageResult {
+    results := make([]listPageResult, last-first+1)
+    pool := gopool.NewPool(*ListWorkers)
+    for page := first; page <= last; page++ {
+        pool.Add(1)
+        go func(page int) {
+            defer pool.Done()
+            pageURL := listPageRe.ReplaceAllString(url, "") + fmt.Sprintf("&page=%d", page)
+            result := &results[page-first]
+            result.episodes, result.last, result.err = getListPage(ctx, pageURL, logger)
+            logger.Printf(pageURL)
+        }(page)
+    }
+    pool.Wait()
+    return results
+}
+
+// titles of announcement entries of the list
+var noticeRe = regexp.MustCompile(`(?i)^[\[(]?\s*(notice|announcement)\b`)
+
+// ListCacheEntry is an episode of the -list-cache file
+type ListCacheEntry struct {
+    URL    string `json:"url"`
+    Title  string `json:"title"`
+    No     int    `json:"no"`
+    Season int    `json:"season,omitempty"`
+}
+
+type ListCacheFile struct {
+    URL      string           `json:"url"`
+    Episodes []ListCacheEntry `json:"episodes"`
+}
+
+// listCacheFile is where -list-cache keeps the episode list of a series,
+// empty without it
+func listCacheFile(opts Opts) string {
+    if !*ListCache {
+        return ""
+    }
+    titre, lang, err := getWebtoonTitle(opts)
+    if err != nil {
+        return ""
+    }
+    return fmt.Sprintf("webtoon/%s/%s/episodes.json", titre, lang)
+}
+
+// loadListCache returns the cached episodes of url, newest first, nil when
+// there is no usable cache
+func loadListCache(file string, url string, logger *log.Logger) []EpisodeInfo {
+    if file == "" {
+        return nil
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil
+    }
+    var cache ListCacheFile
+    if err := json.Unmarshal(data, &cache); err != nil || cache.URL != url || len(cache.Episodes) == 0 {
+        logger.Printf("ignoring episode list cache %s", file)
+        return nil
+    }
+    episodes := make([]EpisodeInfo, 0, len(cache.Episodes))
+    for _, entry := range cache.Episodes {
+        // episode numbers are part of the url, a mismatch means a stale format
+        if episodeNo(entry.URL) != entry.No {
+            logger.Printf("ignoring episode list cache %s", file)
+            return nil
+        }
+        episodes = append(episodes, EpisodeInfo{url: entry.URL, title: entry.Title, season: entry.Season})
+    }
+    return episodes
+}
+
+func saveListCache(file string, url string, episodes []EpisodeInfo, logger *log.Logger) {
+    if file == "" {
+        return
+    }
+    cache := ListCacheFile{URL: url}
+    for _, episode := range episodes {
+        cache.Episodes = append(cache.Episodes, ListCacheEntry{URL: episode.url, Title: episode.title, No: episodeNo(episode.url), Season: episode.season})
+    }
+    data, err := json.MarshalIndent(cache, "", "  ")
+    if err == nil {
+        os.MkdirAll(filepath.Dir(file), 0755)
+        err = os.WriteFile(file, data, 0644)
+    }
+    if err != nil {
+        logger.Printf("WARNING: could not save episode list cache %s: %v", file, err)
+    }
+}
+
+// isNotice reports announcement entries of the list, their title starts
+// with a notice marker. Entries without an episode_no are left to
+// -skip-unnumbered
+func isNotice(episode EpisodeInfo) bool {
+    return noticeRe.MatchString(strings.TrimSpace(episode.title))
+}
+
+// pinnedEpisodes returns the entries of a list page, newest first, older
+// than an entry after them, i.e. pinned on top of every page
+func pinnedEpisodes(episodes []EpisodeInfo) map[string]bool {
+    pinned := make(map[string]bool)
+    newest := 0.0
+    for i := len(episodes) - 1; i >= 0; i-- {
+        if episodeNo(episodes[i].url) == 0 {
+            continue
+        }
+        order := episodeOrder(episodes[i].url)
+        if order < newest {
+            pinned[episodes[i].url] = true
+        } else {
+            newest = order
+        }
+    }
+    return pinned
+}
+
+// getAllEpisodeLinks scans the list pages until one brings no new episode,
+// -max-list-pages or the ctx deadline (-list-timeout) bound the scan. Pages
+// linked from the pagination are fetched concurrently, without pagination
+// pages are probed one at a time
+func getAllEpisodeLinks(ctx context.Context, url string, cacheFile string, logger *log.Logger) ([]EpisodeInfo, error) {
+    episodeSet := make(map[string]struct{})
+    // keep discovery order so episodes sharing an episode_no stay stable
+    var discovered []EpisodeInfo
+    cached := loadListCache(cacheFile, url, logger)
+    cachedTitles := make(map[string]string)
+    for _, episode := range cached {
+        cachedTitles[episode.url] = episode.title
+    }
+    complete := true
+    last := 0
+    for page := 1; ; {
+        if page > *MaxListPages {
+            complete = false
+            logger.Printf("WARNING: stopped scanning after %d list pages, some episodes may be missing", *MaxListPages)
+            break
+        }
+        upTo := page
+        if last > page {
+            upTo = last
+        }
+        if upTo > *MaxListPages {
+            upTo = *MaxListPages
+        }
+        if cached != nil && upTo > page+*ListWorkers-1 {
+            // the known episodes are likely on the next pages already
+            upTo = page + *ListWorkers - 1
+        }
+        results := getListPages(ctx, url, page, upTo, logger)
+        if ctx.Err() != nil {
+            complete = false
+            logger.Printf("WARNING: stopped scanning at list page %d: %v, some episodes may be missing", page, ctx.Err())
+            break
+        }
+        // when you go past the last page, it just rerenders the last page,
+        // so stop on pages without any new episode rather than on the
+        // first repeat which may just be a pinned episode
+        newEpisodes := 0
+        reachedCache := false
+        for i, result := range results {
+            if result.err != nil && page+i == 1 {
+                // keep errGone visible, the series itself is gone
+                return nil, fmt.Errorf("list page %d: %w", page+i, result.err)
+            }
+            if result.err != nil {
+                return nil, fmt.Errorf("list page %d: %v", page+i, result.err)
+            }
+            pinned := pinnedEpisodes(result.episodes)
+            for _, episode := range result.episodes {
+                if pinned[episode.url] {
+                    // older than the page, the cache says nothing about it
+                } else if title, ok := cachedTitles[episode.url]; ok && cached != nil {
+                    reachedCache = true
+                    if title != episode.title {
+                        logger.Printf("episode list of %s changed (%q was %q), scanning it again", url, episode.title, title)
+                        os.Remove(cacheFile)
+                        return getAllEpisodeLinks(ctx, url, cacheFile, logger)
+                    }
+                } else if reachedCache && cached != nil {
+                    // older than a cached episode but not in the cache
+                    logger.Printf("episode list of %s changed (%s not cached), scanning it again", url, episode.url)
+                    os.Remove(cacheFile)
+                    return getAllEpisodeLinks(ctx, url, cacheFile, logger)
+                }
+                if _, ok := episodeSet[episode.url]; ok {
+                    continue
+                }
+                episodeSet[episode.url] = struct{}{}
+                discovered = append(discovered, episode)
+                newEpisodes++
+            }
+            if result.last > last {
+                last = result.last
+            }
+        }
+        if reachedCache {
+            // the rest of the list is cached, newest first like the pages
+            for _, episode := range cached {
+                if _, ok := episodeSet[episode.url]; !ok {
+                    episodeSet[episode.url] = struct{}{}
+                    discovered = append(discovered, episode)
+                }
+            }
+            logger.Printf("scanned %d list pages, %d episodes from %s", upTo, len(cached), cacheFile)
+            break
+        }
+        if newEpisodes == 0 {
+            break
+        }
+        page = upTo + 1
+    }
+    if complete {
+        saveListCache(cacheFile, url, discovered, logger)
+    }
+
+    // list pages go from newest to oldest, walk them backwards
+    allEpisode := make([]EpisodeInfo, 0, len(discovered))
+    for i := len(discovered) - 1; i >= 0; i-- {
+        if *SkipUnnumbered && episodeNo(discovered[i].url) == 0 {
+            logger.Printf("skipping episode without episode_no: %s", discovered[i].url)
+            continue
+        }
+        if !*IncludeNotices && isNotice(discovered[i]) {
+            logger.Printf("skipping notice %q: %s", discovered[i].title, discovered[i].url)
+            continue
+        }
+        allEpisode = append(allEpisode, discovered[i])
+    }
+    // extract episode_no from url and sort by it, episodes without one
+    // stay after the episode listed before them instead of bunching up first
+    orders := make(map[string]float64, len(allEpisode))
+    previous := 0.0
+    for _, episode := range allEpisode {
+        if episodeNo(episode.url) != 0 {
+            previous = episodeOrder(episode.url)
+        }
+        orders[episode.url] = previous
+    }
+    sort.SliceStable(allEpisode, func(i, j int) bool {
+        return orders[allEpisode[i].url] < orders[allEpisode[j].url]
+    })
+    return allEpisode, nil
+}
+
+// some url variants carry the number in the path, e.g. .../episode-12/viewer
+// or .../episode-10-5/viewer for the bonus 10.5
+var episodePathRe = regexp.MustCompile(`(?i)/(?:episode|ep)[-_]?([0-9]+)(?:[.-]([0-9]+))?(?:/|\?|#|$)`)
+
+var episodeNoRe = regexp.MustCompile(`episode_no=([0-9]+)(?:\.([0-9]+))?`)
+
+func episodeNo(episodeLink string) int {
+    episodeNo, _ := episodeNumber(episodeLink)
+    return episodeNo
+}
+
+// episodeNumber is the whole episode_no of a link and the digits after its
+// decimal point, "" for whole episodes, e.g. 10 and "5" for the bonus 10.5.
+// -min-ep, -max-ep and the database only see the whole number
+func episodeNumber(episodeLink string) (int, string) {
+//    log.Printf("%s",episodeLink)
+    matches := episodeNoRe.FindStringSubmatch(episodeLink)
+    if matches == nil {
+        matches = episodePathRe.FindStringSubmatch(episodeLink)
+    }
+    if matches == nil {
+        log.Printf("episodeNo not found in %s", episodeLink)
+        return 0, ""
+    }
+
+    episodeNo, err := strconv.Atoi(matches[1])
+
+    if err != nil {
+        log.Printf("episodeNo %s",matches[1])
+        return 0, ""
+    }
+    return episodeNo, matches[2]
+}
+
+// episodeOrder sorts bonus episodes between their neighbours, 10 < 10.25
+// < 10.5 < 11
+func episodeOrder(episodeLink string) float64 {
+    episodeNo, minor := episodeNumber(episodeLink)
+    if minor == "" {
+        return float64(episodeNo)
+    }
+    order, err := strconv.ParseFloat(fmt.Sprintf("%d.%s", episodeNo, minor), 64)
+    if err != nil {
+        return float64(episodeNo)
+    }
+    return order
+}
+
+// episodeLabel is epNo zero-padded to width with the decimal part of bonus
+// episodes, for file names and pages
+func episodeLabel(epNo int, minor string, width int) string {
+    label := fmt.Sprintf("%0*d", width, epNo)
+    if minor != "" {
+        label += "." + minor
+    }
+    return label
+}
+
+func minorOf(episodeLink string) string {
+    _, minor := episodeNumber(episodeLink)
+    return minor
+}
+
+// minor is the decimal part of the i-th episode of the batch
+func (b EpisodeBatch) minor(i int) string {
+    if i < len(b.minors) {
+        return b.minors[i]
+    }
+    return ""
+}
+
+// labels are the episodeLabel of each episode of the batch, saved episodes
+// are tracked by them so a bonus 10.5 is told apart from 10
+func (b EpisodeBatch) labels() []string {
+    var labels []string
+    for i, epNo := range b.episodeNos {
+        labels = append(labels, episodeLabel(epNo, b.minor(i), 0))
+    }
+    return labels
+}
+
+// link is the page url of the i-th episode, empty when unknown
+func (b EpisodeBatch) link(i int) string {
+    if i >= 0 && i < len(b.links) {
+        return b.links[i]
+    }
+    return ""
+}
+
+// sourceURLs returns the pages of the first and last episode of the batch,
+// seriesURL when they are unknown, e.g. from the manifest of an older version
+func (b EpisodeBatch) sourceURLs(seriesURL string) (string, string) {
+    first, last := b.link(0), b.link(len(b.episodeNos)-1)
+    if first == "" || last == "" {
+        return seriesURL, seriesURL
+    }
+    return first, last
+}
+
+func (b EpisodeBatch) skippedLabels() []string {
+    var labels []string
+    for i, epNo := range b.skipped {
+        minor := ""
+        if i < len(b.skippedMinors) {
+            minor = b.skippedMinors[i]
+        }
+        labels = append(labels, episodeLabel(epNo, minor, 0))
+    }
+    return labels
+}
+
+// getImgLinksForEpisodes fills the pages of a batch, episodes whose page
+// can't be scraped are skipped and kept so they can be reported as missing
+func getImgLinksForEpisodes(episodeLinks []string, episodeTitles []string, actualMaxEp int, logger *log.Logger) EpisodeBatch {
+    var batch EpisodeBatch
+    for i, episodeLink := range episodeLinks {
+        logger.Printf("fetching image links for episode %d/%d", episodeNo(episodeLink), actualMaxEp)
+        imgLinks, note, _, err := getImgLinksForEpisode(episodeLink)
+        if err != nil {
+            logger.Printf("ERROR skipping episode %d: %v", episodeNo(episodeLink), err)
+            batch.skipped = append(batch.skipped, episodeNo(episodeLink))
+            batch.skippedMinors = append(batch.skippedMinors, minorOf(episodeLink))
+            continue
+        }
+        batch.imgLinks = append(batch.imgLinks, imgLinks...)
+        batch.notes = append(batch.notes, note)
+        batch.episodeNos = append(batch.episodeNos, episodeNo(episodeLink))
+        batch.minors = append(batch.minors, minorOf(episodeLink))
+        batch.titles = append(batch.titles, episodeTitles[i])
+        batch.pageCounts = append(batch.pageCounts, len(imgLinks))
+        batch.links = append(batch.links, episodeLink)
+    }
+    return batch
+}
+
+// newHTTPClient tunes the default transport for many requests to the same
+// few CDN hosts, HTTP/2 is negotiated when the host supports it
+func newHTTPClient(maxIdleConns, maxConnsPerHost int, tlsConfig *tls.Config) *http.Client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.ForceAttemptHTTP2 = true
+    if tlsConfig != nil {
+        transport.TLSClientConfig = tlsConfig
+    }
+    transport.MaxIdleConns = maxIdleConns
+    transport.MaxIdleConnsPerHost = maxIdleConns
+    transport.MaxConnsPerHost = maxConnsPerHost
+    return &http.Client{Transport: transport}
+}
+
+// a sha256 in lowercase hex, -pin-cert fingerprints and -skip-image-hashes
+var sha256HexRe = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// getTLSConfig is nil for the default verification, caCert adds a PEM file
+// to the system roots, e.g. the certificate of an inspecting proxy. pinCert
+// is a comma-separated list of sha256 fingerprints, one of them has to be
+// the fingerprint of a certificate of the chain every host presents
+func getTLSConfig(insecureSkipVerify bool, caCert string, pinCert string) (*tls.Config, error) {
+    if !insecureSkipVerify && caCert == "" && pinCert == "" {
+        return nil, nil
+    }
+    config := &tls.Config{}
+    if insecureSkipVerify {
+        log.Printf("WARNING: TLS certificates are not verified, connections can be intercepted")
+        config.InsecureSkipVerify = true
+    }
+    if caCert != "" {
+        pem, err := os.ReadFile(caCert)
+        if err != nil {
+            return nil, fmt.Errorf("ca-cert: %v", err)
+        }
+        pool, err := x509.SystemCertPool()
+        if err != nil {
+            pool = x509.NewCertPool()
+        }
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("ca-cert: no certificate found in %s", caCert)
+        }
+        config.RootCAs = pool
+    }
+    if pinCert != "" {
+        pins := make(map[string]bool)
+        for _, pin := range strings.Split(pinCert, ",") {
+            // as printed by openssl x509 -fingerprint -sha256
+            pin = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+            if !sha256HexRe.MatchString(pin) {
+                return nil, fmt.Errorf("pin-cert: %q is not a sha256 fingerprint", pin)
+            }
+            pins[pin] = true
+        }
+        config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+            for _, raw := range rawCerts {
+                if pins[fmt.Sprintf("%x", sha256.Sum256(raw))] {
+                    return nil
+                }
+            }
+            subject := "unknown certificate"
+            if len(rawCerts) > 0 {
+                if leaf, err := x509.ParseCertificate(rawCerts[0]); err == nil {
+                    subject = leaf.Subject.CommonName
+                    if subject == "" && len(leaf.DNSNames) > 0 {
+                        subject = leaf.DNSNames[0]
+                    }
+                }
+                subject = fmt.Sprintf("%s (sha256 %x)", subject, sha256.Sum256(rawCerts[0]))
+            }
+            return fmt.Errorf("pin-cert: %s matches none of the pinned fingerprints, the connection may be intercepted", subject)
+        }
+    }
+    return config, nil
+}
+
+// headers sent to each host, matched by host suffix in order, the last
+// profile is the default used for webtoons.com and its image CDN
+var hostHeaders = []struct {
+    suffix  string
+    headers map[string]string
+}{
+    // oz/motiontoon images and their json
+    {"ewebtoon-phinf.pstatic.net", map[string]string{"Referer": "https://www.webtoons.com/", "Origin": "https://www.webtoons.com"}},
+    {"apis.naver.com", map[string]string{"Referer": "https://www.webtoons.com/", "Origin": "https://www.webtoons.com", "Accept": "application/json"}},
+    {"", map[string]string{"Referer": "http://www.webtoons.com"}},
+}
+
+// headers given with -header, sent with every request after the host profile
+var extraHeaders = http.Header{}
+
+// headerFlag collects the repeatable -header "Key: Value" flag
+type headerFlag struct{}
+
+func (headerFlag) String() string {
+    return ""
+}
+
+func (headerFlag) Set(value string) error {
+    key, val, ok := strings.Cut(value, ":")
+    key = strings.TrimSpace(key)
+    if !ok || key == "" || strings.ContainsAny(key, " \t") {
+        return fmt.Errorf("header must be of the form \"Key: Value\", got %q", value)
+    }
+    extraHeaders.Add(key, strings.TrimSpace(val))
+    return nil
+}
+
+func setHostHeaders(req *http.Request) {
+    for _, profile := range hostHeaders {
+        if strings.HasSuffix(req.URL.Hostname(), profile.suffix) {
+            for key, value := range profile.headers {
+                req.Header.Set(key, value)
+            }
+            break
+        }
+    }
+    if *Polite {
+        req.Header.Set("User-Agent", politeUserAgents[rand.Intn(len(politeUserAgents))])
+    }
+    for key, values := range extraHeaders {
+        req.Header[key] = values
+    }
+}
+
+// pauses of -polite, on top of those of the normal mode, each one gets up
+// to as much again of random jitter
+const politePageDelay = 2 * time.Second
+const politeImageDelay = time.Second
+
+// user agents -polite picks from for each request
+var politeUserAgents = []string{
+    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+    "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+    "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+    "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+}
+
+// jitter is a random duration up to d
+func jitter(d time.Duration) time.Duration {
+    if d <= 0 {
+        return 0
+    }
+    return time.Duration(rand.Int63n(int64(d)))
+}
+
+var errAuthExpired = errors.New("authentication expired")
+
+// errGone is a page answering 404 or 410, for the first list page the
+// series was removed or moved
+var errGone = errors.New("page gone")
+
+// isLoginURL reports the login pages expired cookies redirect to
+func isLoginURL(u *url.URL) bool {
+    host := u.Hostname()
+    return host == "nid.naver.com" || strings.Contains(u.Path, "/member/login") || strings.HasSuffix(u.Path, "/login")
+}
+
+// checkRedirect fails requests that ended on a login page
+func checkRedirect(resp *http.Response) error {
+    if resp.Request != nil && resp.Request.URL != nil && isLoginURL(resp.Request.URL) {
+        return fmt.Errorf("%w: redirected to %s", errAuthExpired, resp.Request.URL)
+    }
+    return nil
+}
+
+func getPage(url string) (string, error) {
+    return getPageContext(runCtx, url)
+}
+
+func getPageContext(ctx context.Context, url string) (string, error) {
+    if *Polite {
+        select {
+        case <-time.After(politePageDelay + jitter(politePageDelay)):
+        case <-ctx.Done():
+            return "", ctx.Err()
+        }
+    }
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return "", err
+    }
+    setHostHeaders(req)
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    if err := checkRedirect(resp); err != nil {
+        return "", err
+    }
+    if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+        return "", fmt.Errorf("%w: status %s", errGone, resp.Status)
+    }
+    if resp.StatusCode >= 400 {
+        return "", fmt.Errorf("unexpected status %s", resp.Status)
+    }
+    // same as soup.Get, pages are converted to utf-8
+    body, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+    if err != nil {
+        return "", err
+    }
+    page, err := io.ReadAll(body)
+    if err != nil {
+        return "", err
+    }
+    return string(page), nil
+}
+
+// bytes of images downloaded by all workers, see -max-total-bytes
+var downloadedBytes int64
+
+// episodes saved and not saved by every GetWebtoon of the run, for the
+// RESULT line printed by main
+var episodesSaved int64
+var episodesFailed int64
+
+func byteCapReached() bool {
+    return *MaxTotalBytes > 0 && atomic.LoadInt64(&downloadedBytes) >= *MaxTotalBytes
+}
+
+// number of attempts for an image before giving up
+const imageAttempts = 3
+
+// image hosts serving the same files, tried in order once every attempt on
+// the original host failed, extended with -mirror-hosts
+var mirrorHosts = map[string][]string{
+    "webtoon-phinf.pstatic.net":  {"swebtoon-phinf.pstatic.net"},
+    "swebtoon-phinf.pstatic.net": {"webtoon-phinf.pstatic.net"},
+}
+
+// images smaller than this can't be valid, the CDN sometimes answers 200
+// with an empty body
+const minImageBytes = 32
+
+var errEmptyImage = errors.New("empty image response")
+
+var errImageHostRefused = errors.New("image host not allowed")
+
+var errUndecodable = errors.New("image can't be decoded")
+
+// skippedImage reports whether err only skips the page instead of failing
+// the batch
+func skippedImage(err error) bool {
+    return errors.Is(err, errEmptyImage) || errors.Is(err, errImageHostRefused)
+}
+
+// imageHostAllowed checks imgLink against -allowed-image-hosts
+func imageHostAllowed(imgLink string) bool {
+    if len(allowedImageHosts) == 0 {
+        return true
+    }
+    u, err := url.Parse(imgLink)
+    if err != nil {
+        return false
+    }
+    host := strings.ToLower(u.Hostname())
+    for _, allowed := range allowedImageHosts {
+        if host == allowed || strings.HasSuffix(host, "."+allowed) {
+            return true
+        }
+    }
+    return false
+}
+
+// fetchImage returns nil when the image stayed empty after every attempt
+// or its host is not allowed, the page is then skipped
+func fetchImage(imgLink string, logger *log.Logger) []byte {
+    var img []byte
+    err := fetchWith(imgLink, func(link string) error {
+        var err error
+        img, err = downloadImage(link)
+        return err
+    }, logger)
+    if err != nil {
+        return nil
+    }
+    return img
+}
+
+// fetchImageToFile is fetchImage writing the image to path as it arrives
+// instead of holding it in memory
+func fetchImageToFile(imgLink string, path string, logger *log.Logger) error {
+    return fetchWith(imgLink, func(link string) error {
+        return downloadImageToFile(link, path)
+    }, logger)
+}
+
+// fetchWith retries download of imgLink, then of its mirrors, and panics
+// when every one of them failed, except for skippedImage errors which are
+// returned so the page gets skipped
+func fetchWith(imgLink string, download func(link string) error, logger *log.Logger) error {
+    if !imageHostAllowed(imgLink) {
+        logger.Printf("WARNING: skipping %s, its host is not in -allowed-image-hosts", imgLink)
+        return fmt.Errorf("%w: %s", errImageHostRefused, imgLink)
+    }
+    var err error
+    for attempt := 1; attempt <= imageAttempts; attempt++ {
+        err = download(imgLink)
+        if err == nil {
+            return nil
+        }
+        if runCtx.Err() != nil {
+            // -fail-fast stopped the run
+            panic(err.Error())
+        }
+        if errors.Is(err, errAuthExpired) {
+            // retrying or a mirror won't help, cookies need renewing
+            println("********************")
+            panic(err.Error())
+        }
+        logger.Printf("attempt %d/%d for %s failed: %v", attempt, imageAttempts, imgLink, err)
+        time.Sleep(time.Duration(attempt) * time.Second)
+    }
+    if u, parseErr := url.Parse(imgLink); parseErr == nil {
+        for _, mirror := range mirrorHosts[u.Hostname()] {
+            u.Host = mirror
+            if !imageHostAllowed(u.String()) {
+                continue
+            }
+            mirrorErr := download(u.String())
+            if mirrorErr == nil {
+                logger.Printf("fetched %s from mirror host %s", imgLink, mirror)
+                return nil
+            }
+            logger.Printf("mirror host %s for %s failed: %v", mirror, imgLink, mirrorErr)
+        }
+    }
+    if errors.Is(err, errEmptyImage) {
+        logger.Printf("WARNING: skipping %s, still empty after %d attempts and mirrors: %v", imgLink, imageAttempts, err)
+        return err
+    }
+    // recovered by saveBatch, the batch goes to the retry queue
+    println("********************")
+    panic(err.Error())
+}
+
+// time the next image request may start at with -image-delay, shared by
+// all workers
+var imageThrottle struct {
+    sync.Mutex
+    next time.Time
+}
+
+// waitImageSlot spaces image requests at least -image-delay apart
+func waitImageSlot() {
+    if *ImageDelay <= 0 {
+        return
+    }
+    imageThrottle.Lock()
+    now := time.Now()
+    start := now
+    if imageThrottle.next.After(now) {
+        start = imageThrottle.next
+    }
+    spacing := *ImageDelay
+    if *Polite {
+        spacing += jitter(*ImageDelay)
+    }
+    imageThrottle.next = start.Add(spacing)
+    imageThrottle.Unlock()
+    time.Sleep(start.Sub(now))
+}
+
+func downloadImage(imgLink string) ([]byte, error) {
+    buff := new(bytes.Buffer)
+    if err := downloadImageTo(imgLink, buff); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// downloadImageToFile writes the image to path, truncated first so a retry
+// doesn't append to a failed attempt
+func downloadImageToFile(imgLink string, path string) error {
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    err = downloadImageTo(imgLink, f)
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+func downloadImageTo(imgLink string, w io.Writer) error {
+    waitImageSlot()
+    req, err := http.NewRequestWithContext(runCtx, "GET", imgLink, nil)
+    if err != nil {
+        return err
+    }
+    setHostHeaders(req)
+    if *AcceptImage != "" {
+        req.Header.Set("Accept", *AcceptImage)
+    }
+
+    response, err := httpClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer func(Body io.ReadCloser) {
+        err := Body.Close()
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }(response.Body)
+
+    if err := checkRedirect(response); err != nil {
+        return err
+    }
+    // an html page is never a valid image, e.g. an error or login page
+    if strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+        return fmt.Errorf("got an html page instead of an image for %s", imgLink)
+    }
+
+    n, err := io.Copy(w, response.Body)
+    atomic.AddInt64(&downloadedBytes, n)
+    if err != nil {
+        return err
+    }
+    // a truncated body otherwise silently yields a cut off image
+    if response.ContentLength >= 0 && n != response.ContentLength {
+        return fmt.Errorf("partial download of %s: got %d of %d bytes", imgLink, n, response.ContentLength)
+    }
+    if n < minImageBytes {
+        return fmt.Errorf("%w: %d bytes for %s", errEmptyImage, n, imgLink)
+    }
+    return nil
+}
+
+// isPlaceholder reports whether img is a known bad image or smaller than
+// -min-image-dimension, as served by the CDN for removed episodes
+func isPlaceholder(img io.ReadSeeker) bool {
+    sum := sha256.New()
+    if _, err := io.Copy(sum, img); err != nil {
+        return false
+    }
+    if skipImageHashes[hex.EncodeToString(sum.Sum(nil))] {
+        return true
+    }
+    if *MinImageDimension > 0 {
+        if _, err := img.Seek(0, io.SeekStart); err != nil {
+            return false
+        }
+        d, _, err := image.DecodeConfig(img)
+        if err == nil && (d.Width < *MinImageDimension || d.Height < *MinImageDimension) {
+            return true
+        }
+    }
+    return false
+}
+
+// episodeOfPage returns the episode_no of the page at idx in the batch, its
+// decimal part and the 1-based page number within that episode
+func episodeOfPage(episodeBatch EpisodeBatch, idx int) (int, string, int) {
+    for i, pages := range episodeBatch.pageCounts {
+        if idx < pages {
+            return episodeBatch.episodeNos[i], episodeBatch.minor(i), idx + 1
+        }
+        idx -= pages
+    }
+    return 0, "", idx + 1
+}
+
+// images of a part fetched by one worker of prefetchImages
+type fetchChunk struct {
+    from int
+    to   int
+    done chan struct{}
+    // what the worker panicked with, if it did
+    err  interface{}
+}
+
+// prefetchImages fetches the images of start to end of a batch with one
+// worker per chunk of -batch-size episodes (a single chunk when it is 0),
+// workers take a slot of fetchSem before fetching. The returned func waits
+// for the chunk holding idx and hands its image over once so files are
+// still assembled in reading order
+func prefetchImages(episodeBatch EpisodeBatch, start int, end int, logger *log.Logger) func(idx int) []byte {
+    imgs := make([][]byte, end-start)
+    chunkOf := make([]*fetchChunk, end-start)
+    var chunks []*fetchChunk
+    offset := 0
+    for i, pages := range episodeBatch.pageCounts {
+        from, to := max(offset, start), min(offset+pages, end)
+        offset += pages
+        if from >= to {
+            continue
+        }
+        if len(chunks) == 0 || *BatchSize > 0 && i%*BatchSize == 0 {
+            chunks = append(chunks, &fetchChunk{from: from, done: make(chan struct{})})
+        }
+        chunks[len(chunks)-1].to = to
+    }
+    if len(chunks) == 0 {
+        chunks = append(chunks, &fetchChunk{from: start, done: make(chan struct{})})
+    }
+    // pages past the counted episodes go with the last chunk
+    chunks[len(chunks)-1].to = end
+
+    for _, chunk := range chunks {
+        for idx := chunk.from; idx < chunk.to; idx++ {
+            chunkOf[idx-start] = chunk
+        }
+        go func(chunk *fetchChunk) {
+            defer close(chunk.done)
+            defer func() { chunk.err = recover() }()
+            fetchSem <- struct{}{}
+            defer func() { <-fetchSem }()
+            for idx := chunk.from; idx < chunk.to; idx++ {
+                if imgLink := episodeBatch.imgLinks[idx]; !strings.Contains(imgLink, ".gif") {
+                    imgs[idx-start] = fetchImage(imgLink, logger)
+                }
+            }
+        }(chunk)
+    }
+
+    return func(idx int) []byte {
+        chunk := chunkOf[idx-start]
+        <-chunk.done
+        if chunk.err != nil {
+            panic(chunk.err)
+        }
+        img := imgs[idx-start]
+        imgs[idx-start] = nil
+        return img
+    }
+}
+
+// prepareImage transcodes formats gopdf and most readers can't handle, like
+// avif, webp or CMYK jpegs, to RGB jpeg and applies the exif orientation of
+// jpegs before they are added to a comic file
+func prepareImage(img []byte) ([]byte, error) {
+    config, format, err := image.DecodeConfig(bytes.NewReader(img))
+    if err != nil {
+        return nil, err
+    }
+    orientation := 1
+    if format == "jpeg" {
+        orientation = jpegOrientation(img)
+    }
+    // gopdf embeds CMYK jpegs as they are, most viewers then show them
+    // color-inverted, decoding and encoding again gives an RGB jpeg
+    if format != "avif" && format != "webp" && orientation == 1 && config.ColorModel != color.CMYKModel {
+        return img, nil
+    }
+    decodeSem <- struct{}{}
+    defer func() { <-decodeSem }()
+    decoded, _, err := image.Decode(bytes.NewReader(img))
+    if err != nil {
+        return nil, err
+    }
+    buff := new(bytes.Buffer)
+    if err := jpeg.Encode(buff, orient(decoded, orientation), &jpeg.Options{Quality: *JpegQuality}); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// modalWidth is the most common width of the images, the widest one on a
+// tie, 0 when none can be read
+func modalWidth(imgs [][]byte) int {
+    counts := make(map[int]int)
+    width := 0
+    for _, img := range imgs {
+        if img == nil {
+            continue
+        }
+        d, _, err := image.DecodeConfig(bytes.NewReader(img))
+        if err != nil {
+            continue
+        }
+        counts[d.Width]++
+        if counts[d.Width] > counts[width] || (counts[d.Width] == counts[width] && d.Width > width) {
+            width = d.Width
+        }
+    }
+    return width
+}
+
+// batchImages holds the images of a whole batch for -uniform-width so all
+// the parts of the batch are scaled to the same width, they are fetched when
+// the first part that is saved needs them
+type batchImages struct {
+    episodeBatch EpisodeBatch
+    logger       *log.Logger
+    imgs         [][]byte
+    width        int
+    fetched      bool
+}
+
+// newBatchImages is nil without -uniform-width
+func newBatchImages(episodeBatch EpisodeBatch, logger *log.Logger) *batchImages {
+    if !*UniformWidth {
+        return nil
+    }
+    return &batchImages{episodeBatch: episodeBatch, logger: logger}
+}
+
+// fetch downloads the images of the batch once and finds their modalWidth
+func (b *batchImages) fetch() {
+    if b.fetched {
+        return
+    }
+    b.fetched = true
+    fetched := prefetchImages(b.episodeBatch, 0, len(b.episodeBatch.imgLinks), b.logger)
+    b.imgs = make([][]byte, len(b.episodeBatch.imgLinks))
+    for idx := range b.imgs {
+        b.imgs[idx] = fetched(idx)
+    }
+    b.width = modalWidth(b.imgs)
+}
+
+// take hands the image at idx over once, like prefetchImages
+func (b *batchImages) take(idx int) []byte {
+    img := b.imgs[idx]
+    b.imgs[idx] = nil
+    return img
+}
+
+// scaleToWidth resizes img to width keeping its aspect ratio, images
+// already that wide are returned as is
+func scaleToWidth(img []byte, width int) ([]byte, error) {
+    d, _, err := image.DecodeConfig(bytes.NewReader(img))
+    if err != nil {
+        return nil, err
+    }
+    if d.Width == width || d.Width == 0 {
+        return img, nil
+    }
+    decodeSem <- struct{}{}
+    defer func() { <-decodeSem }()
+    src, _, err := image.Decode(bytes.NewReader(img))
+    if err != nil {
+        return nil, err
+    }
+    bounds := src.Bounds()
+    height := bounds.Dy() * width / bounds.Dx()
+    if height < 1 {
+        height = 1
+    }
+    dst := image.NewRGBA(image.Rect(0, 0, width, height))
+    draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+    buff := new(bytes.Buffer)
+    if err := jpeg.Encode(buff, dst, &jpeg.Options{Quality: *JpegQuality}); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// fitDimension downscales img so neither its width nor its height exceeds
+// limit, keeping its aspect ratio, smaller images are returned as is
+func fitDimension(img []byte, limit int) ([]byte, error) {
+    d, _, err := image.DecodeConfig(bytes.NewReader(img))
+    if err != nil {
+        return nil, err
+    }
+    if d.Width <= limit && d.Height <= limit {
+        return img, nil
+    }
+    if d.Height > d.Width {
+        return scaleToWidth(img, max(1, d.Width*limit/d.Height))
+    }
+    return scaleToWidth(img, limit)
+}
+
+// prepareImageFile is prepareImage for an image saved at path, the file is
+// only read back and rewritten when it has to be transcoded
+func prepareImageFile(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    config, format, err := image.DecodeConfig(f)
+    if err != nil {
+        return err
+    }
+    orientation := 1
+    if format == "jpeg" {
+        // the exif segment is at most 64KB, right after the start of image
+        head := make([]byte, 1<<16+4)
+        n, err := f.ReadAt(head, 0)
+        if err != nil && err != io.EOF {
+            return err
+        }
+        orientation = jpegOrientation(head[:n])
+    }
+    if format != "avif" && format != "webp" && orientation == 1 && config.ColorModel != color.CMYKModel {
+        return nil
+    }
+    if _, err := f.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    decodeSem <- struct{}{}
+    defer func() { <-decodeSem }()
+    decoded, _, err := image.Decode(f)
+    if err != nil {
+        return err
+    }
+    out, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    err = jpeg.Encode(out, orient(decoded, orientation), &jpeg.Options{Quality: *JpegQuality})
+    if closeErr := out.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+// jpegOrientation reads the exif orientation tag from the jpeg headers,
+// 1 (upright) when there is none
+func jpegOrientation(img []byte) int {
+    if len(img) < 4 || img[0] != 0xFF || img[1] != 0xD8 {
+        return 1
+    }
+    for i := 2; i+4 <= len(img) && img[i] == 0xFF; {
+        marker := img[i+1]
+        size := int(img[i+2])<<8 | int(img[i+3])
+        // start of scan, no more headers
+        if marker == 0xDA || size < 2 || i+2+size > len(img) {
+            return 1
+        }
+        segment := img[i+4 : i+2+size]
+        if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+            return exifOrientation(segment[6:])
+        }
+        i += 2 + size
+    }
+    return 1
+}
+
+func exifOrientation(tiff []byte) int {
+    var order binary.ByteOrder
+    switch string(tiff[:2]) {
+    case "II":
+        order = binary.LittleEndian
+    case "MM":
+        order = binary.BigEndian
+    default:
+        return 1
+    }
+    ifd := int(order.Uint32(tiff[4:8]))
+    if ifd+2 > len(tiff) {
+        return 1
+    }
+    entries := int(order.Uint16(tiff[ifd : ifd+2]))
+    for e := 0; e < entries; e++ {
+        entry := ifd + 2 + e*12
+        if entry+12 > len(tiff) {
+            return 1
+        }
+        if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+            orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+            if orientation < 1 || orientation > 8 {
+                return 1
+            }
+            return orientation
+        }
+    }
+    return 1
+}
+
+// orient rotates and flips src according to an exif orientation so it is
+// upright
+func orient(src image.Image, orientation int) image.Image {
+    if orientation == 1 {
+        return src
+    }
+    b := src.Bounds()
+    w, h := b.Dx(), b.Dy()
+    dw, dh := w, h
+    if orientation >= 5 {
+        // 90 degrees rotations swap width and height
+        dw, dh = h, w
+    }
+    dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+    for y := 0; y < dh; y++ {
+        for x := 0; x < dw; x++ {
+            var sx, sy int
+            switch orientation {
+            case 2:
+                sx, sy = w-1-x, y
+            case 3:
+                sx, sy = w-1-x, h-1-y
+            case 4:
+                sx, sy = x, h-1-y
+            case 5:
+                sx, sy = y, x
+            case 6:
+                sx, sy = y, h-1-x
+            case 7:
+                sx, sy = w-1-y, h-1-x
+            case 8:
+                sx, sy = w-1-y, x
+            }
+            dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+        }
+    }
+    return dst
+}
+
+func getComicFile(format string, dir string) ComicFile {
+    var comic ComicFile
+    var err error
+    comic = newPDFComicFile()
+    if format == "cbz" {
+        comic, err = newCBZComicFile(dir)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }
+    if format == "pdf-zip" {
+        comic, err = newPDFZipComicFile(dir)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }
+    if format == "dir" {
+        comic, err = newDirComicFile(dir)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+    }
+    return comic
+}
+
+// readerTarget is the flags -target sets for a reader, each unless it was
+// given on the command line or in the config file
+type readerTarget struct {
+    // name and value pairs, in the order they are set
+    flags [][2]string
+}
+
+// flags picked by -target for each reader, epub/mobi are not supported so
+// kindle gets pdf which it reads natively, e-readers get images downscaled
+// to 2000 px so oversized strips don't stall them
+var readerTargets = map[string]readerTarget{
+    "kobo":      {flags: [][2]string{{"format", "cbz"}, {"max-image-dimension", "2000"}}},
+    "kindle":    {flags: [][2]string{{"format", "pdf"}, {"max-image-dimension", "2000"}}},
+    "komga":     {flags: [][2]string{{"format", "cbz"}, {"cover", "true"}, {"provenance", "true"}}},
+    "tachiyomi": {flags: [][2]string{{"layout", "tachiyomi"}}},
+}
+
+// getReaderTarget returns the flags of -target name, epub and mobi readers
+// get an error pointing to the targets that cover them
+func getReaderTarget(name string) (readerTarget, error) {
+    if readerTarget, ok := readerTargets[name]; ok {
+        return readerTarget, nil
+    }
+    if name == "epub" || name == "mobi" {
+        return readerTarget{}, fmt.Errorf("target %s is not supported, webtoon-dl only writes pdf, cbz and image folders: use -target kobo (cbz) or kindle (pdf)", name)
+    }
+    return readerTarget{}, fmt.Errorf("unknown target %s, target must be one of kobo, kindle, komga or tachiyomi", name)
+}
+
+type Opts struct {
+    url        string
+    minEp      int
+    maxEp      int
+    epsPerFile int
+    format     string
+    seriesName string
+    // digits of zero-padded episode numbers in file names, see episodeWidth
+    epWidth    int
+    // log of the webtoon, its own file with -series-log
+    logger     *log.Logger
+    // keep only the newest episodes, -initial-limit on a first sync
+    latestOnly int
+    // start of the run, the download time recorded by -provenance
+    started    time.Time
+}
+
+func parseOpts(args []string) Opts {
+    return parseFlags(flag.CommandLine, args)
+}
+
+// parseFlags defines the shared flags on fs, the flat command line or the
+// flag set of a subcommand, and parses args[1:] with it
+func parseFlags(fs *flag.FlagSet, args []string) Opts {
+
+    if len(args) < 2 {
+        fmt.Println("Usage: webtoon-dl <url>")
+        os.Exit(1)
+    }
+
+    database = fs.Bool("db", false, "Database mode get url from sqlfile for multiple webtoon")
+    DBFile = fs.String("db-file", "./database.db", "Path of the SQLite database")
+    confOverride = fs.Bool("confOverride", false, "Override database config by parameter store in database")
+    FileVerify = fs.Bool("file", false, "Skip files that already exist (default behavior, kept for compatibility)")
+    Force = fs.Bool("force", false, "Always re-download and overwrite existing files")
+
+    NoLog = fs.Bool("NoLog", false, "print output")
+    AcceptImage = fs.String("accept-image", "", "Accept header sent with image requests, e.g. image/webp,image/jpeg to allow smaller webp or image/jpeg to force jpeg")
+    SaveHTML = fs.String("save-html", "", "Folder where the html of every scraped list and episode page is written")
+    MaxImageDimension = fs.Int("max-image-dimension", 0, "Downscale images wider or taller than this many pixels, keeping their aspect ratio, for readers with a size limit (0 to keep all)")
+    MinImageDimension = fs.Int("min-image-dimension", 0, "Skip images narrower or shorter than this many pixels as placeholders (0 to keep all)")
+    skipHashes := fs.String("skip-image-hashes", "", "Comma-separated sha256 of placeholder images to skip")
+    PageGap = fs.Int("page-gap", 0, "Blank space in points added under each image in PDF files (0 for seamless strips)")
+    Cover = fs.Bool("cover", false, "Add the series cover as the first page of each file (tagged FrontCover in cbz ComicInfo.xml)")
+    DecodeWorkers = fs.Int("decode-workers", runtime.NumCPU(), "Maximum number of images decoded or re-encoded at the same time")
+    Output = fs.String("o", "", "Set to - to write the file to stdout, only for a single episode or batch in one format")
+    MaxTotalBytes = fs.Int64("max-total-bytes", 0, "Stop starting new batches once this many image bytes were downloaded (0 for no limit)")
+    CheckPageCounts = fs.Bool("check-page-counts", false, "Record the page count of each episode and warn when it changes on a later run")
+    ResumeFrom = fs.Int("resume-from", 0, "Download from this episode number to the latest available one")
+    JpegQuality = fs.Int("jpeg-quality", 90, "Quality (1-100) of images re-encoded to jpeg")
+    PrintLinks = fs.Bool("print-links", false, "Print the image links of each episode to stdout instead of downloading")
+    MaxPagesPerFile = fs.Int("max-pages-per-file", 0, "Split files with more pages than this into numbered parts (0 for no limit)")
+    EpsPerVolume = fs.Int("eps-per-volume", 0, "Group files in Vol_<n> folders of this many episodes (0 to keep a flat folder)")
+    Season = fs.Int("season", 0, "Only download episodes whose title is labeled with this season")
+    BatchDelay = fs.Duration("batch-delay", 0, "Pause before starting each next webtoon in -db mode (e.g. 30s)")
+    OnlyNew = fs.Bool("only-new", false, "Only download episodes after the last chapter saved in the database for this url")
+    FailOnGaps = fs.Bool("fail-on-gaps", false, "Exit with an error if any requested episode was not saved")
+    IncludeNotes = fs.Bool("include-notes", false, "Append the author's notes as a final text page")
+    NotesFont = fs.String("notes-font", "", "TTF font used to render notes in PDF files (default bundled Go font)")
+    mirrors := fs.String("mirror-hosts", "", "Extra image mirror hosts tried when a download fails, comma-separated host=mirror pairs")
+    CBZEpisodeNames = fs.Bool("cbz-episode-names", false, "Name cbz entries ep<episode>_p<page>.jpg instead of a plain counter")
+    CompressPDF = fs.Int("compress-pdf", 0, "Downsample images embedded in PDF files to this DPI, pages are laid out at 128 (0 to embed images as they are)")
+    IncludeNotices = fs.Bool("include-notices", false, "Keep notice entries of the episode list (a title starting with Notice/Announcement), see -skip-unnumbered for entries without episode_no")
+    SkipUnnumbered = fs.Bool("skip-unnumbered", false, "Exclude episodes without a numeric episode_no")
+    ImageQuality = fs.String("image-quality", "q70", "Quality requested for oz/motiontoon images (e.g. q90, or original to drop the parameter)")
+    ListWorkers = fs.Int("list-workers", 4, "Number of episode list pages fetched at the same time")
+    MaxListPages = fs.Int("max-list-pages", 1000, "Stop scanning the episode list of a series after this many pages")
+    ListTimeout = fs.Duration("list-timeout", 0, "Stop scanning the episode list of a series after this long (e.g. 5m, 0 for no limit)")
+    Layout = fs.String("layout", "", "Output layout, tachiyomi saves webtoon/<lang>/<series>/<episode>/###.jpg for the local source instead of pdf/cbz files")
+    Toc = fs.Bool("toc", false, "Start files of several episodes with a generated table of contents page of their numbers and titles")
+    ManifestFile = fs.String("manifest", "", "JSON file of the image links to download, written after scraping when it doesn't exist, read instead of scraping when it does")
+    FailFast = fs.Bool("fail-fast", false, "Stop every download on the first episode or image error and exit with status 1, failed batches are not retried")
+    ImageDelay = fs.Duration("image-delay", 0, "Minimum time between two image requests across all workers (e.g. 250ms, 0 for no delay)")
+    EpPadWidth = fs.Int("ep-pad-width", 0, "Digits episode numbers are zero-padded to in file and folder names (0 to keep the width of the files already saved, or infer it from the latest episode, at least 3)")
+    imageHosts := fs.String("allowed-image-hosts", "", "Comma-separated image hosts (and their subdomains) to fetch from, other images are skipped and logged, e.g. pstatic.net (empty for any host)")
+    CDNNames = fs.Bool("cdn-names", false, "Name cbz entries and files of folder layouts after the image file names on the CDN, repeated names get a _2, _3... suffix")
+    Polite = fs.Bool("polite", false, fmt.Sprintf("Slow but safe preset: one webtoon, episode and list page at a time, jittered pauses of at least %s between pages and %s between images, rotating browser user agents", politePageDelay, politeImageDelay))
+    Provenance = fs.Bool("provenance", false, "Record the source url and download time in the pdf info (Subject, CreationDate) and cbz ComicInfo.xml (Web, Notes)")
+    ListCache = fs.Bool("list-cache", false, "Keep the episode list of each series in webtoon/<series>/<lang>/episodes.json and only scan the list pages with new episodes on the next runs")
+    KeepGoingOnDecodeError = fs.Bool("keep-going-on-decode-error", false, "Add a gray page naming the episode, page and url of images that can't be decoded instead of failing the batch")
+    InitialLimit = fs.Int("initial-limit", 0, "With -db, only download the latest N episodes of a series never synced before (last_chapter 0), later syncs get every new episode, 0 for all")
+    BatchSize = fs.Int("batch-size", 0, "Fetch the images of each file in chunks of this many episodes at the same time, -eps-per-file still decides what goes in a file, the chunks are held in memory until their turn, 0 fetches a file in order")
+    UniformWidth = fs.Bool("uniform-width", false, "Scale the images of each batch, all its parts and episode folders, to their most common width for seamless vertical scrolling, the images of a batch are then held in memory")
+    SortBy = fs.String("sort-by", "asc", "Order batches are downloaded in, asc or desc for the newest episodes first, pages inside a file stay in reading order")
+    SeriesLog = fs.Bool("series-log", false, "Log each webtoon to webtoon/<series>/<lang>/webtoon-dl.log, the global log only keeps a summary")
+    Preview = fs.Int("preview", 0, "Only download the first N images of each episode, files get a _preview suffix and the database is not updated (0 for whole episodes)")
+    Add = fs.Bool("add", false, "Register the url in the database with its -format and -eps-per-file for later -db runs, nothing is downloaded")
+    ValidateOnly = fs.String("validate-only", "", "Check the cbz and pdf files under this folder and report the suspect ones, nothing is downloaded")
+    Merge = fs.String("merge", "", "Merge the existing files of this output folder into a single merged.<format> in episode order")
+    SelfCheck = fs.Bool("self-check", false, "Check the scraping patterns against the sample pages built in and exit, they are also checked quietly on every start")
+    Doctor = fs.String("doctor", "", "Check scraping end-to-end for the given url without writing any comic file")
+
+    EpisodeGoroutine = fs.Int("E", 10, "Number of episode per webtoon download in the same time")
+    WebtoonGoroutine = fs.Int("W", 3, "Numer of webtoon download in the same time")
+    MaxWebtoonGoroutine= fs.Bool("MW", false, "Treat all webtoon at once")
+    fs.IntVar(EpisodeGoroutine, "episode-concurrency", 10, "Alias of -E")
+    fs.IntVar(EpisodeGoroutine, "threads", 10, "Alias of -E")
+    fs.IntVar(WebtoonGoroutine, "webtoon-concurrency", 3, "Alias of -W")
+    fs.BoolVar(MaxWebtoonGoroutine, "all-webtoons", false, fmt.Sprintf("Alias of -MW, overrides -W with the number of webtoons (at most %d)", maxWebtoonGoroutines))
+
+    minEp := fs.Int("min-ep", 0, "Minimum episode number to download (inclusive)")
+    maxEp := fs.Int("max-ep", math.MaxInt, "Maximum episode number to download (inclusive)")
+
+    epsPerFile := fs.Int("eps-per-file", 1, "Number of episodes to put in each PDF file (0 for all episodes in one file)")
+    format := fs.String("format", "pdf", "Output format (pdf, cbz or pdf-zip for a zip of one pdf per episode, or several comma-separated e.g. pdf,cbz)")
+    maxIdleConns := fs.Int("max-idle-conns", 100, "Maximum number of idle HTTP connections kept open")
+    maxConnsPerHost := fs.Int("max-conns-per-host", 32, "Maximum number of HTTP connections per host (0 for no limit)")
+    seriesName := fs.String("series-name", "", "Name used for the output folder and database instead of the url slug")
+    target := fs.String("target", "", "Reader to set up the output for: kobo (cbz, -max-image-dimension 2000), kindle (pdf, -max-image-dimension 2000), komga (cbz, -cover, -provenance) or tachiyomi (-layout tachiyomi), flags given explicitly override it")
+    fs.Var(headerFlag{}, "header", "Header added to every request, \"Key: Value\", can be repeated")
+    insecureSkipVerify := fs.Bool("insecure-skip-verify", false, "INSECURE: don't verify TLS certificates, only for inspecting proxies that can't be trusted with -ca-cert")
+    caCert := fs.String("ca-cert", "", "PEM file of extra certificate authorities to trust, e.g. a corporate proxy")
+    pinCert := fs.String("pin-cert", "", "Comma-separated sha256 fingerprints of certificates, every page and image host has to present one of them in its chain")
+    fromURL := fs.String("from-url", "", "Viewer url of the first episode to download, used with -to-url instead of min-ep/max-ep")
+    toURL := fs.String("to-url", "", "Viewer url of the last episode to download (inclusive)")
+    config := fs.String("config", "", "TOML file of default flag values, keys are flag names (tables only group them, arrays set repeatable flags like header), command-line flags override it")
+    fs.Parse(args[1:])
+
+    if *config != "" {
+        if err := loadConfig(fs, *config); err != nil {
+            fmt.Println(fmt.Sprintf("config %s: %v", *config, err))
+            os.Exit(1)
+        }
+    }
+
+    for _, f := range strings.Split(*format, ",") {
+        if f != "pdf" && f != "cbz" && f != "pdf-zip" {
+            fmt.Println("format must be pdf, cbz, pdf-zip or a comma-separated list of them")
+            os.Exit(1)
+        }
+    }
+    if *JpegQuality < 1 || *JpegQuality > 100 {
+        fmt.Println("jpeg-quality must be between 1 and 100")
+        os.Exit(1)
+    }
+    if *MaxPagesPerFile < 0 {
+        fmt.Println("max-pages-per-file must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *EpsPerVolume < 0 {
+        fmt.Println("eps-per-volume must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *SaveHTML != "" {
+        if err := os.MkdirAll(*SaveHTML, 0755); err != nil {
+            fmt.Println(fmt.Sprintf("could not create save-html folder: %v", err))
+            os.Exit(1)
+        }
+    }
+    for _, pair := range strings.Split(*mirrors, ",") {
+        if strings.TrimSpace(pair) == "" {
+            continue
+        }
+        host, mirror, ok := strings.Cut(strings.TrimSpace(pair), "=")
+        if !ok || host == "" || mirror == "" {
+            fmt.Println("mirror-hosts must be a comma-separated list of host=mirror")
+            os.Exit(1)
+        }
+        mirrorHosts[host] = append(mirrorHosts[host], mirror)
+    }
+    if *ImageDelay < 0 {
+        fmt.Println("image-delay must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *EpPadWidth < 0 {
+        fmt.Println("ep-pad-width must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *CompressPDF < 0 {
+        fmt.Println("compress-pdf must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *EpisodeGoroutine < 1 {
+        fmt.Println("E (episode-concurrency) must be greater than 0")
+        os.Exit(1)
+    }
+    if *WebtoonGoroutine < 1 {
+        fmt.Println("W (webtoon-concurrency) must be greater than 0")
+        os.Exit(1)
+    }
+    if *ListWorkers < 1 {
+        fmt.Println("list-workers must be greater than 0")
+        os.Exit(1)
+    }
+    if *MaxListPages < 1 {
+        fmt.Println("max-list-pages must be greater than 0")
+        os.Exit(1)
+    }
+    if *ListTimeout < 0 {
+        fmt.Println("list-timeout must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *MinImageDimension < 0 {
+        fmt.Println("min-image-dimension must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *MaxImageDimension < 0 {
+        fmt.Println("max-image-dimension must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    for _, hash := range strings.Split(*skipHashes, ",") {
+        hash = strings.ToLower(strings.TrimSpace(hash))
+        if hash == "" {
+            continue
+        }
+        if !sha256HexRe.MatchString(hash) {
+            fmt.Println("skip-image-hashes must be a comma-separated list of sha256 hex digests")
+            os.Exit(1)
+        }
+        skipImageHashes[hash] = true
+    }
+    if *PageGap < 0 {
+        fmt.Println("page-gap must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *DecodeWorkers < 1 {
+        fmt.Println("decode-workers must be greater than 0")
+        os.Exit(1)
+    }
+    decodeSem = make(chan struct{}, *DecodeWorkers)
+    if *Output != "" && *Output != "-" {
+        fmt.Println("-o only supports - (stdout)")
+        os.Exit(1)
+    }
+    if *Output == "-" && (*database || strings.Contains(*format, ",")) {
+        fmt.Println("-o - can't be used with -db or with several formats")
+        os.Exit(1)
+    }
+    allowedImageHosts = nil
+    for _, host := range strings.Split(*imageHosts, ",") {
+        host = strings.ToLower(strings.TrimSpace(host))
+        if host == "" {
+            continue
+        }
+        if strings.ContainsAny(host, "/:") {
+            fmt.Println("allowed-image-hosts must be a comma-separated list of host names")
+            os.Exit(1)
+        }
+        allowedImageHosts = append(allowedImageHosts, host)
+    }
+    if *CDNNames && *CBZEpisodeNames {
+        fmt.Println("cdn-names can't be used with cbz-episode-names")
+        os.Exit(1)
+    }
+    if *Polite {
+        *EpisodeGoroutine = 1
+        *WebtoonGoroutine = 1
+        *MaxWebtoonGoroutine = false
+        *ListWorkers = 1
+        if *ImageDelay < politeImageDelay {
+            *ImageDelay = politeImageDelay
+        }
+    }
+    if *InitialLimit < 0 {
+        fmt.Println("initial-limit must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *BatchSize < 0 {
+        fmt.Println("batch-size must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    fetchSem = make(chan struct{}, *EpisodeGoroutine)
+    if *SortBy != "asc" && *SortBy != "desc" {
+        fmt.Println("sort-by must be asc or desc")
+        os.Exit(1)
+    }
+    if *Preview < 0 {
+        fmt.Println("preview must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *Add && *database {
+        fmt.Println("add can't be used with -db")
+        os.Exit(1)
+    }
+    if *ManifestFile != "" && *database {
+        fmt.Println("manifest can't be used with -db")
+        os.Exit(1)
+    }
+    if *seriesName != "" && *database {
+        fmt.Println("series-name can't be used with -db")
+        os.Exit(1)
+    }
+    if strings.ContainsAny(*seriesName, "/\\") {
+        fmt.Println("series-name can't contain path separators")
+        os.Exit(1)
+    }
+    if *maxIdleConns < 0 || *maxConnsPerHost < 0 {
+        fmt.Println("max-idle-conns and max-conns-per-host must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    tlsConfig, err := getTLSConfig(*insecureSkipVerify, *caCert, *pinCert)
+    if err != nil {
+        fmt.Println(err.Error())
+        os.Exit(1)
+    }
+    httpClient = newHTTPClient(*maxIdleConns, *maxConnsPerHost, tlsConfig)
+
+    if *target != "" {
+        readerTarget, err := getReaderTarget(*target)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+        set := make(map[string]bool)
+        fs.Visit(func(f *flag.Flag) {
+            set[f.Name] = true
+        })
+        for _, nameValue := range readerTarget.flags {
+            name, value := nameValue[0], nameValue[1]
+            // an explicit -format also replaces the tachiyomi layout
+            if set[name] || (name == "layout" && set["format"]) {
+                continue
+            }
+            if err := fs.Set(name, value); err != nil {
+                fmt.Println(err.Error())
+                os.Exit(1)
+            }
+        }
+    }
+
+    if *Layout != "" {
+        if *Layout != "tachiyomi" {
+            fmt.Println("layout must be tachiyomi")
+            os.Exit(1)
+        }
+        if *Output == "-" {
+            fmt.Println("-o - can't be used with -layout")
+            os.Exit(1)
+        }
+        // pages are written as plain files, see DirComicFile
+        *format = "dir"
+    }
+
+    if *Force && *FileVerify {
+        fmt.Println("-force and -file cannot be used together")
+        os.Exit(1)
+    }
+    if *ImageQuality != "original" && !imageQualityRe.MatchString(*ImageQuality) {
+        fmt.Println("image-quality must be of the form q<number> or original")
+        os.Exit(1)
+    }
+    if *ResumeFrom > 0 {
+        rangeSet := false
+        fs.Visit(func(f *flag.Flag) {
+            if f.Name == "min-ep" || f.Name == "max-ep" {
+                rangeSet = true
+            }
+        })
+        if rangeSet {
+            fmt.Println("resume-from can't be used with min-ep or max-ep")
+            os.Exit(1)
+        }
+        *minEp = *ResumeFrom
+    }
+    url := args[len(args)-1]
+    if *fromURL != "" || *toURL != "" {
+        if *fromURL == "" || *toURL == "" {
+            fmt.Println("from-url and to-url must be used together")
+            os.Exit(1)
+        }
+        rangeSet := false
+        fs.Visit(func(f *flag.Flag) {
+            if f.Name == "min-ep" || f.Name == "max-ep" || f.Name == "resume-from" {
+                rangeSet = true
+            }
+        })
+        if rangeSet {
+            fmt.Println("from-url and to-url can't be used with min-ep, max-ep or resume-from")
+            os.Exit(1)
+        }
+        if getListURL(*fromURL) != getListURL(*toURL) {
+            fmt.Println("from-url and to-url must be episodes of the same series")
+            os.Exit(1)
+        }
+        from, to := episodeNo(*fromURL), episodeNo(*toURL)
+        if from == 0 || to == 0 {
+            fmt.Println("from-url and to-url must carry an episode number")
+            os.Exit(1)
+        }
+        if from > to {
+            from, to = to, from
+        }
+        *minEp, *maxEp = from, to
+        url = getListURL(*fromURL)
+    }
+    if *minEp > *maxEp {
+        fmt.Println("min-ep must be less than or equal to max-ep")
+        os.Exit(1)
+    }
+    if *epsPerFile < 0 {
+        fmt.Println("eps-per-file must be greater than or equal to 0")
+        os.Exit(1)
+    }
+    if *minEp < 0 {
+        fmt.Println("min-ep must be greater than or equal to 0")
+        os.Exit(1)
+    }
+
+    return Opts{
+        url:        url,
+        minEp:      *minEp,
+        maxEp:      *maxEp,
+        epsPerFile: *epsPerFile,
+        format:     *format,
+        seriesName: *seriesName,
+        logger:     log.Default(),
+        started:    time.Now(),
+    }
+}
+
+// flags defined as aliases of another one in parseFlags, by alias
+var flagAliases = map[string]string{
+    "episode-concurrency": "E",
+    "threads":             "E",
+    "webtoon-concurrency": "W",
+    "all-webtoons":        "MW",
+}
+
+// canonicalFlag is the flag an alias stands for, name for the others
+func canonicalFlag(name string) string {
+    if canonical, ok := flagAliases[name]; ok {
+        return canonical
+    }
+    return name
+}
+
+// loadConfig sets the flags listed in a TOML config file, e.g.
+//
+//    # comments and blank lines are ignored
+//    format = "cbz"
+//    header = ["Accept-Language: en", "DNT: 1"]
+//
+//    [network]
+//    E = 4
+//    batch-delay = "30s"
+//
+// keys are flag names, tables only group them, every element of an array is
+// set in turn for repeatable flags like -header. Flags given on the command
+// line keep their value, also when the file uses one of their aliases
+func loadConfig(fs *flag.FlagSet, file string) error {
+    var values map[string]interface{}
+    md, err := toml.DecodeFile(file, &values)
+    if err != nil {
+        return err
+    }
+    setOnCommandLine := make(map[string]bool)
+    fs.Visit(func(f *flag.Flag) {
+        setOnCommandLine[canonicalFlag(f.Name)] = true
+    })
+    setInFile := make(map[string]string)
+    for _, key := range md.Keys() {
+        switch md.Type(key...) {
+        case "Hash":
+            // tables only group the flags
+            continue
+        case "ArrayHash":
+            return fmt.Errorf("%s: arrays of tables are not supported", key)
+        }
+        name := key[len(key)-1]
+        if name == "config" {
+            return fmt.Errorf("%s: config can't be set from a config file", key)
+        }
+        if fs.Lookup(name) == nil {
+            return fmt.Errorf("%s: unknown flag %s", key, name)
+        }
+        canonical := canonicalFlag(name)
+        if other, ok := setInFile[canonical]; ok {
+            return fmt.Errorf("%s: -%s is already set by %s", key, canonical, other)
+        }
+        setInFile[canonical] = key.String()
+        if setOnCommandLine[canonical] {
+            continue
+        }
+        value := interface{}(values)
+        for _, part := range key {
+            value = value.(map[string]interface{})[part]
+        }
+        args, err := configValues(value)
+        if err != nil {
+            return fmt.Errorf("%s: %v", key, err)
+        }
+        for _, arg := range args {
+            if err := fs.Set(name, arg); err != nil {
+                return fmt.Errorf("%s: %v", key, err)
+            }
+        }
+    }
+    return nil
+}
+
+// configValues are the arguments of a config value as flag.Value.Set takes
+// them, one per element of an array
+func configValues(value interface{}) ([]string, error) {
+    switch v := value.(type) {
+    case string:
+        return []string{v}, nil
+    case bool:
+        return []string{strconv.FormatBool(v)}, nil
+    case int64:
+        return []string{strconv.FormatInt(v, 10)}, nil
+    case float64:
+        return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+    case []interface{}:
+        var args []string
+        for _, element := range v {
+            if _, ok := element.([]interface{}); ok {
+                return nil, errors.New("nested arrays are not supported")
+            }
+            arg, err := configValues(element)
+            if err != nil {
+                return nil, err
+            }
+            args = append(args, arg...)
+        }
+        return args, nil
+    }
+    return nil, fmt.Errorf("unsupported value %v, quote it", value)
+}
+
+// batchName puts the padded episode range in front of the batch title so
+// files sort in episode order and mergeFolder can read the order back
+func batchName(opts Opts, episodeBatch EpisodeBatch) string {
+    minLabel := episodeLabel(episodeBatch.minEp, "", opts.epWidth)
+    maxLabel := episodeLabel(episodeBatch.maxEp, "", opts.epWidth)
+    if last := len(episodeBatch.episodeNos) - 1; last >= 0 {
+        if episodeBatch.episodeNos[0] == episodeBatch.minEp {
+            minLabel = episodeLabel(episodeBatch.minEp, episodeBatch.minor(0), opts.epWidth)
+        }
+        if episodeBatch.episodeNos[last] == episodeBatch.maxEp {
+            maxLabel = episodeLabel(episodeBatch.maxEp, episodeBatch.minor(last), opts.epWidth)
+        }
+    }
+    if minLabel != maxLabel {
+        return fmt.Sprintf("epNo%s-epNo%s %s", minLabel, maxLabel, episodeBatch.title)
+    }
+    return fmt.Sprintf("epNo%s %s", minLabel, episodeBatch.title)
+}
+
+// renameLegacyOutput moves a file saved under the title-only name of older
+// versions to its numbered name, so the existence check in savePart still
+// skips it
+func renameLegacyOutput(legacyPath string, outPath string, formats string) {
+    for _, format := range strings.Split(formats, ",") {
+        ext := "." + format
+        if format == "pdf-zip" {
+            ext = ".zip"
+        }
+        if format == "dir" {
+            continue
+        }
+        if _, err := os.Stat(outPath + ext); err == nil {
+            continue
+        }
+        if _, err := os.Stat(legacyPath + ext); err == nil {
+            os.Rename(legacyPath+ext, outPath+ext)
+        }
+    }
+}
+
+func getWebtoonTitle(opts Opts) (string,string,error) {
+
+    _, err :=url.ParseRequestURI(opts.url)
+    if err != nil {
+            return "","",err
+
+    }
+
+    outURL := strings.ReplaceAll(opts.url, "http://", "")
+    outURL = strings.ReplaceAll(outURL, "https://", "")
+    outURL = strings.ReplaceAll(outURL, "www.", "")
+    outURL = strings.ReplaceAll(outURL, "webtoons.com/", "")
+    parts := strings.Split(outURL, "/")
+    if len(parts) < 3 || strings.ContainsAny(parts[2], "?=") {
+        // shared links like /en/viewer?title_no=95&episode_no=1 have no slug
+        u, _ := url.Parse(opts.url)
+        titleNo := u.Query().Get("title_no")
+        if titleNo == "" {
+            return "", "", fmt.Errorf("%s has neither a series slug nor a title_no", opts.url)
+        }
+        lang, slug, err := seriesFromTitleNo(u, titleNo)
+        if err != nil {
+            return "", "", err
+        }
+        parts = []string{lang, "", slug}
+    }
+    lang := parts[0]
+    title := parts[2]
+    if opts.seriesName != "" {
+        title = opts.seriesName
+    }
+    return title, lang, nil
+}
+
+func seriesListURL(u *url.URL, titleNo string) string {
+    return fmt.Sprintf("%s://%s/episodeList?titleNo=%s", u.Scheme, u.Host, url.QueryEscape(titleNo))
+}
+
+// lang and slug of the title_no values resolved by seriesFromTitleNo
+var seriesByTitleNo sync.Map
+
+// seriesFromTitleNo finds the lang and url slug of a series from the
+// canonical url of its list page
+func seriesFromTitleNo(u *url.URL, titleNo string) (string, string, error) {
+    if cached, ok := seriesByTitleNo.Load(titleNo); ok {
+        series := cached.([2]string)
+        return series[0], series[1], nil
+    }
+    listURL := seriesListURL(u, titleNo)
+    resp, err := getPage(listURL)
+    time.Sleep(200 * time.Millisecond)
+    if err != nil {
+        return "", "", fmt.Errorf("error fetching series of title_no %s: %w", titleNo, err)
+    }
+    saveHTML(listURL, resp)
+    var canonical string
+    for _, meta := range soup.HTMLParse(resp).FindAll("meta") {
+        if meta.Attrs()["property"] == "og:url" {
+            canonical = meta.Attrs()["content"]
+            break
+        }
+    }
+    c, err := url.Parse(canonical)
+    if canonical == "" || err != nil {
+        return "", "", fmt.Errorf("no series url found for title_no %s", titleNo)
+    }
+    // e.g. /en/fantasy/tower-of-god/list
+    segments := strings.Split(strings.Trim(c.Path, "/"), "/")
+    if len(segments) < 3 {
+        return "", "", fmt.Errorf("no series slug in %s for title_no %s", canonical, titleNo)
+    }
+    seriesByTitleNo.Store(titleNo, [2]string{segments[0], segments[2]})
+    return segments[0], segments[2], nil
+}
+
+
+
+
+//get the series list page url from an episode viewer url
+func getListURL(episodeURL string) string {
+    if !strings.Contains(episodeURL, "/viewer") {
+        return episodeURL
+    }
+    u, err := url.Parse(episodeURL)
+    if err != nil {
+        return episodeURL
+    }
+    // e.g. /en/fantasy/tower-of-god/season-1-ep-0/viewer
+    segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+    if len(segments) < 3 && u.Query().Get("title_no") != "" {
+        // no slug, the site redirects this one to the list page
+        return seriesListURL(u, u.Query().Get("title_no"))
+    }
+    if len(segments) < 3 {
+        return episodeURL
+    }
+    u.Path = "/" + strings.Join(segments[:3], "/") + "/list"
+    u.RawQuery = "title_no=" + u.Query().Get("title_no")
+    return u.String()
+}
+
+// needsSeriesFile reports whether a series level file like cover.jpg is
+// missing or has to be written again with -force
+func needsSeriesFile(outFile string) bool {
+    _, err := os.Stat(outFile)
+    return err != nil || *Force
+}
+
+//save the series thumbnail of the list page, once per webtoon unless -force
+func saveCover(doc soup.Root, outFile string) error {
+    if !needsSeriesFile(outFile) {
+        return nil
+    }
+    var coverURL string
+    for _, meta := range doc.FindAll("meta") {
+        if meta.Attrs()["property"] == "og:image" {
+            coverURL = meta.Attrs()["content"]
+            break
+        }
+    }
+    if coverURL == "" {
+        return errors.New("no thumbnail found")
+    }
+    // same -allowed-image-hosts check as the pages
+    if !imageHostAllowed(coverURL) {
+        return fmt.Errorf("%w: %s", errImageHostRefused, coverURL)
+    }
+    img, err := downloadImage(coverURL)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(outFile, img, 0644)
+}
+
+// SeriesInfo is the series level metadata of the list page, written to
+// series.json
+type SeriesInfo struct {
+    Title   string `json:"title"`
+    Author  string `json:"author,omitempty"`
+    Genre   string `json:"genre,omitempty"`
+    Summary string `json:"summary,omitempty"`
+    Status  string `json:"status,omitempty"`
+    Rating  string `json:"rating,omitempty"`
+    URL     string `json:"url"`
+}
+
+// findText is the trimmed text of the first tag with the given class, ""
+// when there is none
+func findText(doc soup.Root, tag string, class string) string {
+    node := doc.Find(tag, "class", class)
+    if node.Error != nil {
+        return ""
+    }
+    return strings.Join(strings.Fields(node.FullText()), " ")
+}
+
+func parseSeriesInfo(doc soup.Root, listURL string) SeriesInfo {
+    info := SeriesInfo{
+        Title:   findText(doc, "h1", "subj"),
+        Author:  findText(doc, "div", "author_area"),
+        Genre:   findText(doc, "h2", "genre"),
+        Summary: findText(doc, "p", "summary"),
+        Status:  findText(doc, "p", "day_info"),
+        URL:     listURL,
+    }
+    if info.Author == "" {
+        info.Author = findText(doc, "a", "author")
+    }
+    if info.Genre == "" {
+        info.Genre = findText(doc, "p", "genre")
+    }
+    if rating := doc.Find("em", "id", "_starScoreAverage"); rating.Error == nil {
+        info.Rating = strings.TrimSpace(rating.Text())
+    }
+    // the author area ends with an author info button
+    info.Author = strings.TrimSpace(strings.TrimSuffix(info.Author, "author info"))
+    for _, meta := range doc.FindAll("meta") {
+        if info.Title == "" && meta.Attrs()["property"] == "og:title" {
+            info.Title = strings.TrimSpace(meta.Attrs()["content"])
+        }
+        if info.Summary == "" && meta.Attrs()["property"] == "og:description" {
+            info.Summary = strings.TrimSpace(meta.Attrs()["content"])
+        }
+    }
+    return info
+}
+
+//save the series metadata from the list page, once per webtoon like the cover
+func saveSeriesInfo(doc soup.Root, listURL string, outFile string) error {
+    if !needsSeriesFile(outFile) {
+        return nil
+    }
+    info, err := json.MarshalIndent(parseSeriesInfo(doc, listURL), "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(outFile, info, 0644)
+}
+
+// getBatchDirectory returns the folder of a batch, with -eps-per-volume
+// batches go in Vol_<n> subfolders based on their first episode
+func getBatchDirectory(title string, lang string, episodeBatch EpisodeBatch) string {
+    outDirectory := fmt.Sprintf("webtoon/%s/%s/", title, lang)
+    if *EpsPerVolume > 0 {
+        volume := 1
+        if episodeBatch.minEp > 0 {
+            volume = (episodeBatch.minEp-1) / *EpsPerVolume + 1
+        }
+        outDirectory += fmt.Sprintf("Vol_%d/", volume)
+    }
+    return outDirectory
+}
+
+// output is a comic file being written by savePart and its final path
+type output struct {
+    path  string
+    comic ComicFile
+}
+
+// addMissingPage adds a page saying the image couldn't be decoded, so the
+// gap is visible when reading, see -keep-going-on-decode-error
+func addMissingPage(outputs []output, epNo int, minor string, page int, imgLink string) error {
+    img, err := renderTextPage(color.Gray{Y: 0xC0}, []string{
+        "MISSING PAGE",
+        "",
+        fmt.Sprintf("episode %s, page %d", episodeLabel(epNo, minor, 0), page),
+        "the image could not be decoded:",
+        imgLink,
+    }, 400)
+    if err != nil {
+        return err
+    }
+    for _, out := range outputs {
+        if cbz, ok := out.comic.(*CBZComicFile); ok && *CBZEpisodeNames {
+            cbz.setPage(epNo, minor, page)
+        }
+        if pdfZip, ok := out.comic.(*PDFZipComicFile); ok {
+            if err := pdfZip.setEpisode(epNo, minor); err != nil {
+                return err
+            }
+        }
+        if err := out.comic.addImage(img); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// savePart saves pages start to end of a batch in every format, files that
+// already exist are skipped unless -force is set. images is nil unless
+// -uniform-width is set
+func savePart(title string, opts Opts, episodeBatch EpisodeBatch, outPath string, start int, end int, notes []string, cover []byte, toc []byte, images *batchImages, progress *Progress) {
+    var err error
+    var outputs []output
+    for _, format := range strings.Split(opts.format, ",") {
+        outFile := fmt.Sprintf("%s.%s", outPath, format)
+        if format == "pdf-zip" {
+            outFile = outPath + ".zip"
+        }
+        if format == "dir" {
+            outFile = outPath
+        }
+        if *Output == "-" {
+            outputs = append(outputs, output{path: "-", comic: getComicFile(format, filepath.Dir(outFile))})
+            continue
+        }
+        _, fileExist := os.Stat(outFile);
+        if (*Force ||  fileExist != nil){
+            outputs = append(outputs, output{path: outFile, comic: getComicFile(format, filepath.Dir(outFile))})
+        }
+    }
+    if len(outputs) == 0 {
+        progress.advance(end - start)
+        return
+    }
+    // the panics below are recovered by saveBatch, the temp files of the
+    // outputs not saved yet go with them
+    saved := make([]bool, len(outputs))
+    defer func() {
+        for i, out := range outputs {
+            if !saved[i] {
+                out.comic.abort()
+            }
+        }
+    }()
+
+    if *Provenance {
+        firstURL, lastURL := episodeBatch.sourceURLs(opts.url)
+        notes := fmt.Sprintf("episodes %d through %d downloaded %s by webtoon-dl", episodeBatch.minEp, episodeBatch.maxEp, opts.started.Format(time.RFC3339))
+        for _, out := range outputs {
+            out.comic.setSource(firstURL, lastURL, notes, opts.started)
+        }
+    }
+
+    if cover != nil {
+        for _, out := range outputs {
+            err := out.comic.addCover(cover)
+            if err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+        }
+    }
+
+    if toc != nil {
+        for _, out := range outputs {
+            var err error
+            if cbz, ok := out.comic.(*CBZComicFile); ok {
+                if *CBZEpisodeNames {
+                    cbz.setPage(0, "", 0)
+                }
+                err = cbz.addPage(toc, "Other")
+            } else {
+                err = out.comic.addImage(toc)
+            }
+            if err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+        }
+    }
+
+    // with -batch-size chunks of the part are fetched ahead, with
+    // -uniform-width every image of the batch is needed to find the width
+    // to use
+    var fetched func(idx int) []byte
+    width := 0
+    if images != nil {
+        images.fetch()
+        width = images.width
+        fetched = images.take
+    } else if *BatchSize > 0 {
+        fetched = prefetchImages(episodeBatch, start, end, opts.logger)
+    }
+
+    // each image is fetched once and handed to every writer
+    for idx := start; idx < end; idx++ {
+        imgLink := episodeBatch.imgLinks[idx]
+        if strings.Contains(imgLink, ".gif") {
+            opts.logger.Printf("WARNING: skipping gif %s", imgLink)
+            progress.advance(1)
+            continue
+        }
+        epNo, minor, page := episodeOfPage(episodeBatch, idx)
+        if dir, ok := outputs[0].comic.(*DirComicFile); ok && len(outputs) == 1 && fetched == nil && *MaxImageDimension == 0 {
+            // no other writer needs the bytes, stream the image to its file
+            added, err := dir.addImageLink(imgLink, opts.logger)
+            if errors.Is(err, errUndecodable) && *KeepGoingOnDecodeError {
+                opts.logger.Printf("WARNING: placeholder for page %d of episode %d: %v", page, epNo, err)
+                err = addMissingPage([]output{{comic: dir}}, epNo, minor, page, imgLink)
+                added = err == nil
+            }
+            if err != nil && !skippedImage(err) {
+                println("********************")
+                panic(err.Error())
+            }
+            if !added && err == nil {
+                opts.logger.Printf("WARNING: skipping placeholder page %d of episode %d: %s", page, epNo, imgLink)
+            }
+            done, total := progress.advance(1)
+            opts.logger.Printf("Title: %s %d/%d pages (saving episodes %d through %d)", title, done, total, episodeBatch.minEp, episodeBatch.maxEp)
+            continue
+        }
+        var img []byte
+        if fetched != nil {
+            img = fetched(idx)
+        } else {
+            img = fetchImage(imgLink, opts.logger)
+        }
+        if img == nil {
+            // logged by fetchImage
+            progress.advance(1)
+            continue
+        }
+        if isPlaceholder(bytes.NewReader(img)) {
+            opts.logger.Printf("WARNING: skipping placeholder page %d of episode %d: %s", page, epNo, imgLink)
+            progress.advance(1)
+            continue
+        }
+        img, err := prepareImage(img)
+        if err != nil && *KeepGoingOnDecodeError {
+            opts.logger.Printf("WARNING: placeholder for page %d of episode %d, %s can't be decoded: %v", page, epNo, imgLink, err)
+            if err := addMissingPage(outputs, epNo, minor, page, imgLink); err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+            progress.advance(1)
+            continue
+        }
+        if err != nil {
+            println("********************")
+            panic(err.Error())
+        }
+        if width > 0 {
+            img, err = scaleToWidth(img, width)
+            if err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+        }
+        if *MaxImageDimension > 0 {
+            img, err = fitDimension(img, *MaxImageDimension)
+            if err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+        }
+        name := ""
+        if *CDNNames {
+            name = cdnName(imgLink, bytes.NewReader(img))
+        }
+        for _, out := range outputs {
+            if cbz, ok := out.comic.(*CBZComicFile); ok && *CBZEpisodeNames {
+                cbz.setPage(epNo, minor, page)
+            }
+            if pdfZip, ok := out.comic.(*PDFZipComicFile); ok {
+                if err := pdfZip.setEpisode(epNo, minor); err != nil {
+                    println("********************")
+                    panic(err.Error())
+                }
+            }
+            if named, ok := out.comic.(interface{ setName(string) }); ok && name != "" {
+                named.setName(name)
+            }
+            err := out.comic.addImage(img)
+            if err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+        }
+
+        done, total := progress.advance(1)
+        opts.logger.Printf(
+                "Title: %s %d/%d pages (saving episodes %d through %d)",
+                title,
+                done,
+                total,
+                episodeBatch.minEp,
+                episodeBatch.maxEp,
+            )
+
+    }
+    for i, out := range outputs {
+        if len(notes) > 0 {
+            err = out.comic.addText(strings.Join(notes, "\n\n"))
+            if err != nil {
+                println("********************")
+                panic(err.Error())
+            }
+        }
+        err = out.comic.save(out.path)
+        if err != nil {
+            println("********************")
+            panic(err.Error())
+        }
+        saved[i] = true
+        opts.logger.Printf("saved to %s", out.path)
+    }
+}
+
+func saveBatch(pool *gopool.GoPool, results chan<- BatchResult, title string, lang string, opts Opts, index int, episodeBatch EpisodeBatch, progress *Progress)  {
+    defer pool.Done()
+    result := BatchResult{index: index, minEp: episodeBatch.minEp, maxEp: episodeBatch.maxEp}
+    defer func() {
+        if err := recover(); err != nil {
+            opts.logger.Printf("Recovered: %v", err)
+            result.err = fmt.Errorf("%v", err)
+        }
+        if result.err != nil {
+            stopOnError(fmt.Errorf("episodes %d-%d: %w", result.minEp, result.maxEp, result.err))
+        }
+        results <- result
+    }()
+
+    if err := runCtx.Err(); err != nil {
+        result.err = err
+        return
+    }
+
+    if len(episodeBatch.imgLinks) == 0 {
+        opts.logger.Printf("WARNING: no image for episodes %d through %d, nothing to save", episodeBatch.minEp, episodeBatch.maxEp)
+        return
+    }
+
+    if *Preview > 0 {
+        before := len(episodeBatch.imgLinks)
+        episodeBatch = previewBatch(episodeBatch, *Preview)
+        progress.grow(len(episodeBatch.imgLinks) - before)
+    }
+
+    images := newBatchImages(episodeBatch, opts.logger)
+    if opts.format == "dir" {
+        saveChapters(title, lang, opts, episodeBatch, images, progress)
+        result.saved = episodeBatch.labels()
+        return
+    }
+
+    var notes []string
+    if *IncludeNotes {
+        for _, note := range episodeBatch.notes {
+            if note != "" {
+                notes = append(notes, note)
+            }
+        }
+    }
+
+    var cover []byte
+    if *Cover {
+        var err error
+        cover, err = os.ReadFile(fmt.Sprintf("webtoon/%s/%s/cover.jpg", title, lang))
+        if err != nil {
+            opts.logger.Printf("WARNING: no cover for episodes %d through %d: %v", episodeBatch.minEp, episodeBatch.maxEp, err)
+            cover = nil
+        }
+    }
+
+    var toc []byte
+    if *Toc && len(episodeBatch.episodeNos) > 1 {
+        var err error
+        toc, err = renderTOC(episodeBatch)
+        if err != nil {
+            opts.logger.Printf("WARNING: no table of contents for episodes %d through %d: %v", episodeBatch.minEp, episodeBatch.maxEp, err)
+            toc = nil
+        }
+    }
+
+    outDirectory := getBatchDirectory(title, lang, episodeBatch)
+    os.MkdirAll(outDirectory, 0755)
+    // with -max-pages-per-file large batches roll over to numbered parts,
+    // zero-padded so they sort in readers
+    pagesPerFile := len(episodeBatch.imgLinks)
+    if *MaxPagesPerFile > 0 && *MaxPagesPerFile < pagesPerFile {
+        pagesPerFile = *MaxPagesPerFile
+    }
+    numParts := (len(episodeBatch.imgLinks) + pagesPerFile - 1) / pagesPerFile
+    width := len(strconv.Itoa(numParts))
+    if width < 2 {
+        width = 2
+    }
+    for part := 0; part < numParts; part++ {
+        start := part * pagesPerFile
+        end := start + pagesPerFile
+        if end > len(episodeBatch.imgLinks) {
+            end = len(episodeBatch.imgLinks)
+        }
+        name := batchName(opts, episodeBatch) + previewSuffix()
+        legacyName := episodeBatch.title + previewSuffix()
+        var partNotes []string
+        var partTOC []byte
+        if part == 0 {
+            partTOC = toc
+        }
+        if numParts > 1 {
+            name = fmt.Sprintf("%s_part%0*d", name, width, part+1)
+            legacyName = fmt.Sprintf("%s_part%0*d", legacyName, width, part+1)
+        }
+        if *Output != "-" {
+            renameLegacyOutput(outDirectory+legacyName, outDirectory+name, opts.format)
+        }
+        if part == numParts-1 {
+            partNotes = notes
+        }
+        savePart(title, opts, episodeBatch, outDirectory+name, start, end, partNotes, cover, partTOC, images, progress)
+    }
+    result.saved = episodeBatch.labels()
+}
+
+// textFace is the Go font at the size of generated pages
+func textFace() (font.Face, error) {
+    parsed, err := opentype.Parse(goregular.TTF)
+    if err != nil {
+        return nil, err
+    }
+    return opentype.NewFace(parsed, &opentype.FaceOptions{Size: 20, DPI: 72, Hinting: font.HintingFull})
+}
+
+// renderTextPage draws lines of text on a page of the given background and
+// minimum height, long lines wrap at the width of renderTOC pages
+func renderTextPage(background color.Color, lines []string, minHeight int) ([]byte, error) {
+    const width = 800
+    const margin = 40
+    const lineHeight = 32
+    face, err := textFace()
+    if err != nil {
+        return nil, err
+    }
+    defer face.Close()
+
+    measure := &font.Drawer{Face: face}
+    var wrapped []string
+    for _, line := range lines {
+        for line != "" && measure.MeasureString(line).Ceil() > width-2*margin {
+            // urls have no spaces, cut at the last rune that fits
+            runes := []rune(line)
+            n := len(runes)
+            for n > 1 && measure.MeasureString(string(runes[:n])).Ceil() > width-2*margin {
+                n--
+            }
+            wrapped = append(wrapped, string(runes[:n]))
+            line = string(runes[n:])
+        }
+        wrapped = append(wrapped, line)
+    }
+
+    height := max(minHeight, 2*margin+(len(wrapped)+1)*lineHeight)
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+    d := &font.Drawer{Dst: img, Src: image.NewUniform(color.Black), Face: face}
+    for i, line := range wrapped {
+        d.Dot = fixed.P(margin, margin+(i+1)*lineHeight)
+        d.DrawString(line)
+    }
+
+    buff := new(bytes.Buffer)
+    if err := jpeg.Encode(buff, img, &jpeg.Options{Quality: *JpegQuality}); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// renderTOC draws the episode numbers and titles of a batch as a jpeg page,
+// as wide as most webtoon images
+func renderTOC(episodeBatch EpisodeBatch) ([]byte, error) {
+    const width = 800
+    const margin = 40
+    const lineHeight = 32
+    const titleX = margin + 100
+    face, err := textFace()
+    if err != nil {
+        return nil, err
+    }
+    defer face.Close()
+
+    height := 2*margin + (len(episodeBatch.episodeNos)+2)*lineHeight
+    img := image.NewRGBA(image.Rect(0, 0, width, height))
+    draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+    d := &font.Drawer{Dst: img, Src: image.NewUniform(color.Black), Face: face}
+    drawText := func(x int, y int, text string) {
+        // cut titles running past the right margin
+        for text != "" && d.MeasureString(text).Ceil() > width-margin-x {
+            runes := []rune(text)
+            text = string(runes[:len(runes)-1])
+        }
+        d.Dot = fixed.P(x, y)
+        d.DrawString(text)
+    }
+    y := margin + lineHeight
+    drawText(margin, y, "Episode")
+    drawText(titleX, y, "Title")
+    y += lineHeight
+    for i, epNo := range episodeBatch.episodeNos {
+        y += lineHeight
+        title := ""
+        if i < len(episodeBatch.titles) {
+            title = episodeBatch.titles[i]
+        }
+        drawText(margin, y, episodeLabel(epNo, episodeBatch.minor(i), 0))
+        drawText(titleX, y, title)
+    }
+
+    buff := new(bytes.Buffer)
+    if err := jpeg.Encode(buff, img, &jpeg.Options{Quality: *JpegQuality}); err != nil {
+        return nil, err
+    }
+    return buff.Bytes(), nil
+}
+
+// previewBatch keeps the first n images of each episode of the batch
+func previewBatch(episodeBatch EpisodeBatch, n int) EpisodeBatch {
+    preview := episodeBatch
+    preview.imgLinks = nil
+    preview.pageCounts = nil
+    page := 0
+    for _, pages := range episodeBatch.pageCounts {
+        kept := min(pages, n)
+        preview.imgLinks = append(preview.imgLinks, episodeBatch.imgLinks[page:page+kept]...)
+        preview.pageCounts = append(preview.pageCounts, kept)
+        page += pages
+    }
+    return preview
+}
+
+// previewSuffix marks -preview output so it isn't taken for a full download
+func previewSuffix() string {
+    if *Preview > 0 {
+        return "_preview"
+    }
+    return ""
+}
+
+// getChapterRoot is the series folder of the tachiyomi layout, the local
+// source expects <series>/<chapter>/ so languages get their own root
+func getChapterRoot(title string, lang string) string {
+    return fmt.Sprintf("webtoon/%s/%s/", lang, title)
+}
+
+// saveChapters saves each episode of a batch as a folder of page files,
+// the tachiyomi local source layout
+func saveChapters(title string, lang string, opts Opts, episodeBatch EpisodeBatch, images *batchImages, progress *Progress) {
+    root := getChapterRoot(title, lang)
+    os.MkdirAll(root, 0755)
+    start := 0
+    for i, epNo := range episodeBatch.episodeNos {
+        end := start + episodeBatch.pageCounts[i]
+        var notes []string
+        if *IncludeNotes && episodeBatch.notes[i] != "" {
+            notes = []string{episodeBatch.notes[i]}
+        }
+        savePart(title, opts, episodeBatch, fmt.Sprintf("%sEpisode %s%s", root, episodeLabel(epNo, episodeBatch.minor(i), opts.epWidth), previewSuffix()), start, end, notes, nil, nil, images, progress)
+        start = end
+    }
+}
+
+// names of files and tachiyomi folders saved with a padded episode number
+var paddedNameRe = regexp.MustCompile(`^(?:epNo|Episode )([0-9]+)`)
+
+// episodeWidth is -ep-pad-width, else the width the files already saved in
+// dirs were padded to, else the digits of the latest episode, at least 3.
+// Once a series has files the width stays the same past episode 999, so the
+// existing files are still found by the skip-existing check
+func episodeWidth(latest int, dirs ...string) int {
+    if *EpPadWidth > 0 {
+        return *EpPadWidth
+    }
+    if width := savedEpisodeWidth(dirs); width > 0 {
+        return width
+    }
+    width := len(strconv.Itoa(latest))
+    if width < 3 {
+        width = 3
+    }
+    return width
+}
+
+// savedEpisodeWidth is the shortest padded episode number of the names in
+// dirs and their Vol_<n> folders, numbers past the padding only get longer,
+// 0 when there is none
+func savedEpisodeWidth(dirs []string) int {
+    width := 0
+    for _, dir := range dirs {
+        volumes, _ := filepath.Glob(filepath.Join(dir, "Vol_*"))
+        for _, d := range append([]string{dir}, volumes...) {
+            entries, err := os.ReadDir(d)
+            if err != nil {
+                continue
+            }
+            for _, entry := range entries {
+                matches := paddedNameRe.FindStringSubmatch(entry.Name())
+                if matches != nil && (width == 0 || len(matches[1]) < width) {
+                    width = len(matches[1])
+                }
+            }
+        }
+    }
+    return width
+}
+
+func GetWebtoon(db *sql.DB, opts Opts)(error){
+    titre,lang,err := getWebtoonTitle (opts)
+
+    if err != nil {
+        panic(err)
+    }
+
+    outDirectory := fmt.Sprintf("webtoon/%s/%s/", titre, lang)
+    os.MkdirAll(outDirectory,0755)
+
+    if *SeriesLog {
+        seriesLog, err := os.OpenFile(outDirectory+"webtoon-dl.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+        if err != nil {
+            panic(err)
+        }
+        defer seriesLog.Close()
+        opts.logger = log.New(seriesLog, "", log.LstdFlags)
+        log.Printf("%s %s: logging to %s", titre, lang, seriesLog.Name())
+    }
+
+    // the list page is fetched once for both series files
+    if needsSeriesFile(outDirectory+"cover.jpg") || needsSeriesFile(outDirectory+"series.json") {
+        listURL := getListURL(opts.url)
+        resp, err := getPage(listURL)
+        time.Sleep(200 * time.Millisecond)
+        if err != nil {
+            opts.logger.Printf("could not save cover and series info: error fetching page: %v", err)
+        } else {
+            doc := soup.HTMLParse(resp)
+            if err := saveCover(doc, outDirectory+"cover.jpg"); err != nil {
+                opts.logger.Printf("could not save cover: %v", err)
+            }
+            if err := saveSeriesInfo(doc, listURL, outDirectory+"series.json"); err != nil {
+                opts.logger.Printf("could not save series info: %v", err)
+            }
+        }
+    }
+    if opts.format == "dir" {
+        // tachiyomi shows the cover.jpg of the series folder
+        os.MkdirAll(getChapterRoot(titre, lang), 0755)
+        if cover, err := os.ReadFile(outDirectory+"cover.jpg"); err == nil {
+            os.WriteFile(getChapterRoot(titre, lang)+"cover.jpg", cover, 0644)
+        }
+    }
+
+    var episodeBatches []EpisodeBatch
+    var latest int
+    if *ManifestFile != "" {
+        episodeBatches, latest, err = getManifestBatches(*ManifestFile, opts)
+    } else {
+        episodeBatches, latest, err = getEpisodeBatches(opts.url, opts.minEp, opts.maxEp, opts.epsPerFile, opts.latestOnly, listCacheFile(opts), opts.logger)
+    }
+
+    if latest > 0 {
+        newEpisodes := 0
+        for _, episodeBatch := range episodeBatches {
+            newEpisodes += len(episodeBatch.episodeNos) + len(episodeBatch.skipped)
+        }
+        opts.logger.Printf("%s: %d new of %d total", titre, newEpisodes, latest)
+        if *database {
+            fmt.Println(fmt.Sprintf("%s: %d new of %d total", titre, newEpisodes, latest))
+        }
+        // keep track of it even when there is nothing new to download
+        _, dbErr := db.Exec("update webtoon set latest_known = ? where titre = ? and lang = ?", latest, titre, lang)
+        if dbErr != nil {
+            opts.logger.Printf("ERROR could not update latest_known: %v", dbErr)
+        }
+    }
+    if errors.Is(err, errNoEpisode) && latest > 0 && opts.minEp > latest {
+        // already up to date, e.g. -only-new or -db
+        opts.logger.Printf("%s: no new episode after %d", titre, latest)
+        return nil
+    }
+    if errors.Is(err, errGone) {
+        // not retried by -db runs until the row is reviewed, see GetWebtoons
+        opts.logger.Printf("GONE %s %s: %v", titre, lang, err)
+        _, dbErr := db.Exec("update webtoon set status = 'gone' where titre = ? and lang = ?", titre, lang)
+        if dbErr != nil {
+            opts.logger.Printf("ERROR could not flag %s as gone: %v", titre, dbErr)
+        }
+        return fmt.Errorf("%s: series gone, flagged in the database: %w", titre, err)
+    }
+    if err != nil {
+        panic(err)
+    }
+    maxEp := latest
+    if last := episodeBatches[len(episodeBatches)-1].maxEp; last > maxEp {
+        maxEp = last
+    }
+    opts.epWidth = episodeWidth(maxEp, outDirectory, getChapterRoot(titre, lang))
+    if *SortBy == "desc" {
+        // only the order batches start in, each one keeps its pages in order
+        for i, j := 0, len(episodeBatches)-1; i < j; i, j = i+1, j-1 {
+            episodeBatches[i], episodeBatches[j] = episodeBatches[j], episodeBatches[i]
+        }
+    }
+
+    if *Output == "-" {
+        // stdout can only carry one file
+        if len(episodeBatches) > 1 {
+            return fmt.Errorf("-o - needs a single file but %d batches were found, use -eps-per-file=0 or a smaller episode range", len(episodeBatches))
+        }
+        if *MaxPagesPerFile > 0 && len(episodeBatches[0].imgLinks) > *MaxPagesPerFile {
+            return fmt.Errorf("-o - can't be used when -max-pages-per-file splits the file")
+        }
+    }
+
+    last_episode :=0
+
+    totalPages := 0
+    for _, episodeBatch := range episodeBatches {
+        totalPages += len(episodeBatch.imgLinks)
+    }
+    progress := newProgress(totalPages)
+    //fmt.Println(fmt.Sprintf("found %d total image links across %d episodes", totalPages, totalEpisodes))
+    //fmt.Println(fmt.Sprintf("saving into %d files with max of %d episodes per file", len(episodeBatches), opts.epsPerFile))
+
+    pool := gopool.NewPool(*EpisodeGoroutine)
+
+    results := make(chan BatchResult, len(episodeBatches))
+    scheduled := 0
+    for i, episodeBatch := range episodeBatches {
+        pool.Add(1)
+        if byteCapReached() {
+            // in-flight batches finish, the rest is left for a later run
+            pool.Done()
+            opts.logger.Printf("WARNING: %s: download cap of %d bytes hit, remaining episodes %d through %d", titre, *MaxTotalBytes, episodeBatches[i].minEp, episodeBatches[len(episodeBatches)-1].maxEp)
+            break
+        }
+        if runCtx.Err() != nil {
+            pool.Done()
+            break
+        }
+        go saveBatch(pool, results, titre, lang, opts, i, episodeBatch, progress)
+        scheduled++
+    }
+    pool.Wait()
+    close(results)
+
+    saved := make(map[string]bool)
+    var failed []string
+    // failed batches are queued for a final pass with fewer workers, which
+    // does better on a flaky network than failing them right away
+    var retryQueue []int
+    for result := range results {
+        if result.err != nil {
+            if *FailFast {
+                failed = append(failed, fmt.Sprintf("episodes %d-%d: %v", result.minEp, result.maxEp, result.err))
+                continue
+            }
+            opts.logger.Printf("WARNING: %s: episodes %d-%d failed, queued for retry: %v", titre, result.minEp, result.maxEp, result.err)
+            retryQueue = append(retryQueue, result.index)
+        }
+        for _, label := range result.saved {
+            saved[label] = true
+        }
+    }
+
+    if len(retryQueue) > 0 {
+        retryWorkers := *EpisodeGoroutine / 2
+        if retryWorkers < 1 {
+            retryWorkers = 1
+        }
+        opts.logger.Printf("%s: retrying %d failed batches with %d workers", titre, len(retryQueue), retryWorkers)
+        retryPool := gopool.NewPool(retryWorkers)
+        retryResults := make(chan BatchResult, len(retryQueue))
+        for _, index := range retryQueue {
+            retryPool.Add(1)
+            progress.grow(len(episodeBatches[index].imgLinks))
+            go saveBatch(retryPool, retryResults, titre, lang, opts, index, episodeBatches[index], progress)
+        }
+        retryPool.Wait()
+        close(retryResults)
+        for result := range retryResults {
+            if result.err != nil {
+                failed = append(failed, fmt.Sprintf("episodes %d-%d: %v", result.minEp, result.maxEp, result.err))
+            }
+            for _, label := range result.saved {
+                saved[label] = true
+            }
+        }
+        if len(failed) > 0 {
+            opts.logger.Printf("ERROR %s: still failing after retry: %s", titre, strings.Join(failed, "; "))
+        }
+    } else if len(failed) > 0 {
+        opts.logger.Printf("ERROR %s: %s", titre, strings.Join(failed, "; "))
+    }
+
+    // check every requested episode ended up in a file
+    var missing []string
+    for _, episodeBatch := range episodeBatches {
+        for _, label := range append(episodeBatch.labels(), episodeBatch.skippedLabels()...) {
+            if !saved[label] {
+                missing = append(missing, label)
+            }
+        }
+    }
+    if len(missing) > 0 {
+        opts.logger.Printf("WARNING: %s: episodes not saved: %s", titre, strings.Join(missing, ", "))
+    }
+    atomic.AddInt64(&episodesSaved, int64(len(saved)))
+    atomic.AddInt64(&episodesFailed, int64(len(missing)))
+    if *SeriesLog {
+        // the details are in the series log
+        log.Printf("%s %s: %d episodes saved, %d not saved", titre, lang, len(saved), len(missing))
+    }
+    if *CheckPageCounts {
+        checkPageCounts(db, titre, lang, episodeBatches, saved, opts.logger)
+    }
+    if scheduled == 0 {
+        return fmt.Errorf("%s: download cap of %d bytes hit before any batch started", titre, *MaxTotalBytes)
+    }
+    stored, _, err := getLastChapter(db, opts)
+    if err != nil {
+        opts.logger.Printf("ERROR could not read last_chapter: %v", err)
+    }
+    last_episode = lastSyncedEpisode(episodeBatches, saved, opts.minEp, stored)
+    latestKnown := latest
+    if last_episode > latestKnown {
+        latestKnown = last_episode
+    }
+
+    if *Preview > 0 {
+        // previews don't count as downloaded episodes
+        opts.logger.Printf("%s: preview, database not updated", titre)
+        if len(failed) > 0 {
+            return fmt.Errorf("%s: %d of %d batches failed: %s", titre, len(failed), len(episodeBatches), strings.Join(failed, "; "))
+        }
+        return nil
+    }
+
+    // series names may contain quotes, let the driver escape values
+    request := "insert or replace into webtoon(titre,lang,url,last_chapter,epsPerFile,format,latest_known) values (?, ?, ?, ?, ?, ?, ?)"
+    opts.logger.Printf("%s %s %s %d %d %s", titre, lang, opts.url, last_episode, opts.epsPerFile, opts.format)
+
+    _, err = db.Exec(request, titre, lang, opts.url, last_episode, opts.epsPerFile, opts.format, latestKnown)
+    if err != nil {
+        panic(err)
+    }
+    if len(failed) > 0 {
+        return fmt.Errorf("%s: %d of %d batches failed: %s", titre, len(failed), len(episodeBatches), strings.Join(failed, "; "))
+    }
+    if len(missing) > 0 && *FailOnGaps {
+        return fmt.Errorf("%s: episodes not saved: %s", titre, strings.Join(missing, ", "))
+    }
+    return nil
+}
+
+
+// checkPageCounts warns when an episode now has a different number of pages
+// than when it was first downloaded, which happens when the site serves a
+// partial list, and records the count of newly saved episodes
+func checkPageCounts(db *sql.DB, titre string, lang string, episodeBatches []EpisodeBatch, saved map[string]bool, logger *log.Logger) {
+    for _, episodeBatch := range episodeBatches {
+        for i, epNo := range episodeBatch.episodeNos {
+            if episodeBatch.minor(i) != "" {
+                // the episode table has one row per whole episode_no
+                continue
+            }
+            pages := episodeBatch.pageCounts[i]
+            var known int
+            err := db.QueryRow("SELECT pages FROM episode WHERE titre = ? AND lang = ? AND episode_no = ?", titre, lang, epNo).Scan(&known)
+            if err == nil {
+                if known != pages {
+                    logger.Printf("WARNING: %s: episode %d has %d pages, %d when first downloaded", titre, epNo, pages, known)
+                }
+                continue
+            }
+            if err != sql.ErrNoRows {
+                logger.Printf("ERROR %v", err)
+                continue
+            }
+            if !saved[episodeLabel(epNo, "", 0)] {
+                continue
+            }
+            _, err = db.Exec("insert into episode(titre,lang,episode_no,pages) values (?, ?, ?, ?)", titre, lang, epNo, pages)
+            if err != nil {
+                logger.Printf("ERROR %v", err)
+            }
+        }
+    }
+}
+
+type WebtoonResult struct {
+    url string
+    err error
+}
+
+func GetWebtoonBatch(pool *gopool.GoPool, results chan<- WebtoonResult, db *sql.DB,opts Opts)(){
+    defer pool.Done()
+    result := WebtoonResult{url: opts.url}
+    defer func() {
+        if err := recover(); err != nil {
+            log.Printf("Recovered: %v", err)
+            result.err = fmt.Errorf("%v", err)
+        }
+        results <- result
+    }()
+    result.err = GetWebtoon(db,opts)
+    if result.err != nil {
+        log.Printf("ERROR %v", result.err)
+        stopOnError(result.err)
+    }
+
+}
+
+// incrementalOpts starts from the last chapter saved in the database,
+// -max-ep then counts the episodes after it
+func incrementalOpts(opts Opts, lastChapter int) Opts {
+    opts.minEp = lastChapter
+    //by default download until the end
+    if opts.maxEp <= math.MaxInt-lastChapter {
+        opts.maxEp = lastChapter + opts.maxEp
+    }
+    return opts
+}
+
+// lastSyncedEpisode is the last_chapter to store after a run: only advance
+// up to the last episode with every earlier one saved, a failed or
+// unscheduled batch is retried on the next sync instead of being skipped,
+// whatever the -sort-by order. It never goes below stored, a run with a
+// lower -min-ep or without any saved episode doesn't move the progress back.
+// last_chapter only keeps the whole number, a failed 10.5 holds it at 10
+func lastSyncedEpisode(episodeBatches []EpisodeBatch, saved map[string]bool, minEp int, stored int) int {
+    type attemptedEpisode struct {
+        no    int
+        label string
+        order float64
+    }
+    var attempted []attemptedEpisode
+    attempt := func(nos []int, labels []string) {
+        for i, label := range labels {
+            order, _ := strconv.ParseFloat(label, 64)
+            attempted = append(attempted, attemptedEpisode{no: nos[i], label: label, order: order})
+        }
+    }
+    for _, episodeBatch := range episodeBatches {
+        attempt(episodeBatch.episodeNos, episodeBatch.labels())
+        attempt(episodeBatch.skipped, episodeBatch.skippedLabels())
+    }
+    sort.SliceStable(attempted, func(i, j int) bool {
+        return attempted[i].order < attempted[j].order
+    })
+    last := 0
+    if minEp > 0 {
+        last = minEp - 1
+    }
+    for _, episode := range attempted {
+        if !saved[episode.label] {
+            break
+        }
+        last = episode.no
+    }
+    return max(last, stored)
+}
+
+//get the last chapter saved in the database for the url's webtoon
+func getLastChapter(db *sql.DB, opts Opts) (int, bool, error) {
+    titre, lang, err := getWebtoonTitle(opts)
+    if err != nil {
+        return 0, false, err
+    }
+    var lastChapter int
+    err = db.QueryRow("SELECT last_chapter FROM webtoon WHERE titre = ? AND lang = ?", titre, lang).Scan(&lastChapter)
+    if err == sql.ErrNoRows {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return lastChapter, true, nil
+}
+
+// addWebtoon registers a series for -db runs, an already registered one
+// keeps its last_chapter, gets the new format and eps-per-file and loses
+// a gone status
+func addWebtoon(db *sql.DB, opts Opts) error {
+    titre, lang, err := getWebtoonTitle(opts)
+    if err != nil {
+        return err
+    }
+    lastChapter := 0
+    if opts.minEp > 0 {
+        lastChapter = opts.minEp - 1
+    }
+    request := "insert into webtoon(titre,lang,url,last_chapter,epsPerFile,format) values (?, ?, ?, ?, ?, ?) on conflict(titre,lang) do update set url = excluded.url, epsPerFile = excluded.epsPerFile, format = excluded.format, status = null"
+    _, err = db.Exec(request, titre, lang, getListURL(opts.url), lastChapter, opts.epsPerFile, opts.format)
+    if err != nil {
+        return err
+    }
+    log.Printf("added %s %s with format %s and %d episodes per file", titre, lang, opts.format, opts.epsPerFile)
+    fmt.Println(fmt.Sprintf("added %s (%s): format=%s eps-per-file=%d", titre, lang, opts.format, opts.epsPerFile))
+    return nil
+}
+
+// GetWebtoons downloads every webtoon of the database and returns the
+// number of webtoons that failed
+func GetWebtoons(db *sql.DB, opts Opts)(int){
+
+    gone, err := db.Query("SELECT titre, lang FROM webtoon WHERE status = 'gone'")
+    if err == nil {
+        for gone.Next() {
+            var titre, lang string
+            if gone.Scan(&titre, &lang) == nil {
+                log.Printf("GONE skipping %s %s, its url answered 404, fix or delete the row and clear its status", titre, lang)
+            }
+        }
+        gone.Close()
+    }
+
+    sqlStmt := "SELECT url,last_chapter,epsPerFile,format FROM webtoon WHERE status IS NULL OR status != 'gone'";
+
+    rows, err := db.Query(sqlStmt)
+    if err != nil {
+        log.Printf("ERROR %q: %s\n", err, sqlStmt)
+        return 1
+    }else{
+        var webtoons []Opts
+        var url string
+        var format string
+        var epsPerFile int
+        var last_chapter int
+        defer rows.Close()
+
+        for rows.Next() {
+            err = rows.Scan( &url, &last_chapter,&epsPerFile,&format)
+            if err != nil {
+                // a bad row should not stop the other webtoons
+                log.Printf("ERROR skipping database row: %v", err)
+                continue
+            }
+            webtoon := incrementalOpts(opts, last_chapter)
+            webtoon.url = url
+            if last_chapter == 0 {
+                // never synced, with -add -min-ep the start is already set
+                webtoon.latestOnly = *InitialLimit
+            }
+            if !*confOverride {
+                webtoon.epsPerFile=epsPerFile
+                webtoon.format=format
+            }
+            webtoons = append(webtoons, webtoon)
+        }
+
+        if *MaxWebtoonGoroutine {
+            *WebtoonGoroutine = min(len(webtoons), maxWebtoonGoroutines)
+            if *WebtoonGoroutine < 1 {
+                *WebtoonGoroutine = 1
+            }
+        }
+        pool := gopool.NewPool(*WebtoonGoroutine)
+
+        results := make(chan WebtoonResult, len(webtoons))
+        for i, opts := range webtoons {
+            pool.Add(1)
+            if byteCapReached() {
+                pool.Done()
+                log.Printf("WARNING: download cap of %d bytes hit, %d webtoons not started", *MaxTotalBytes, len(webtoons)-i)
+                break
+            }
+            if runCtx.Err() != nil {
+                pool.Done()
+                log.Printf("WARNING: -fail-fast stopped the run, %d webtoons not started", len(webtoons)-i)
+                break
+            }
+            if i > 0 && *BatchDelay > 0 {
+                // once a slot is free, spread the load on the CDN over time
+                time.Sleep(*BatchDelay)
+            }
+            go GetWebtoonBatch(pool, results, db, opts)
+
+        }
+        pool.Wait()
+        close(results)
+
+        var succeeded, failed []string
+        var retry []Opts
+        for result := range results {
+            if result.err == nil {
+                succeeded = append(succeeded, result.url)
+                continue
+            }
+            if errors.Is(result.err, errGone) || runCtx.Err() != nil || byteCapReached() {
+                failed = append(failed, result.url)
+                continue
+            }
+            for _, webtoon := range webtoons {
+                if webtoon.url == result.url {
+                    retry = append(retry, webtoon)
+                }
+            }
+        }
+
+        // transient failures often pass on a second, gentler try: one
+        // webtoon and one episode at a time, from the chapter saved by the
+        // first pass
+        if len(retry) > 0 {
+            log.Printf("retrying %d failed webtoons with -E 1", len(retry))
+            defer func(episodeGoroutine int) { *EpisodeGoroutine = episodeGoroutine }(*EpisodeGoroutine)
+            *EpisodeGoroutine = 1
+            retryPool := gopool.NewPool(1)
+            retryResults := make(chan WebtoonResult, len(retry))
+            for _, opts := range retry {
+                if lastChapter, found, err := getLastChapter(db, opts); err == nil && found {
+                    opts.minEp = max(opts.minEp, lastChapter)
+                }
+                retryPool.Add(1)
+                go GetWebtoonBatch(retryPool, retryResults, db, opts)
+            }
+            retryPool.Wait()
+            close(retryResults)
+            for result := range retryResults {
+                if result.err != nil {
+                    failed = append(failed, result.url)
+                } else {
+                    log.Printf("%s succeeded on retry", result.url)
+                    succeeded = append(succeeded, result.url)
+                }
+            }
+        }
+
+        summary := fmt.Sprintf("%d webtoons succeeded, %d failed", len(succeeded), len(failed))
+        for _, url := range succeeded {
+            summary += "\n  ok: " + url
+        }
+        for _, url := range failed {
+            summary += "\n  failed: " + url
+        }
+        log.Println(summary)
+        fmt.Println(summary)
+        return len(failed)
+    }
+}
+
+var mergeNameRe = regexp.MustCompile(`^epNo([0-9]+)(?:\.([0-9]+))?[ -]`)
+
+// -preview and -max-pages-per-file suffixes saveBatch adds to batch names
+var mergeSuffixRe = regexp.MustCompile(`(_preview)?(?:_part([0-9]+))?\.[^.]+$`)
+
+// mergeOrder is the first episode number batchName wrote in front of a file
+// name, ordered like episodeOrder so bonus episodes sort 10.25 < 10.5
+func mergeOrder(name string) (float64, error) {
+    matches := mergeNameRe.FindStringSubmatch(name)
+    if matches == nil {
+        return 0, fmt.Errorf("%s is not named after its episodes (epNo<n>...), rename or move it before merging", name)
+    }
+    number := matches[1]
+    if matches[2] != "" {
+        number += "." + matches[2]
+    }
+    order, err := strconv.ParseFloat(number, 64)
+    if err != nil {
+        return 0, fmt.Errorf("%s: %v", name, err)
+    }
+    return order, nil
+}
+
+// mergeFolder concatenates the files of a webtoon output folder, including
+// its Vol_<n> subfolders, into dir/merged.<format> in episode order
+func mergeFolder(dir string, format string) (err error) {
+    defer func() {
+        // gofpdi panics on unreadable pdf files
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+
+    outFile := filepath.Join(dir, "merged."+format)
+    if _, statErr := os.Stat(outFile); statErr == nil && !*Force {
+        return fmt.Errorf("%s already exists, use -force to overwrite it", outFile)
+    }
+
+    files, err := filepath.Glob(filepath.Join(dir, "*."+format))
+    if err != nil {
+        return err
+    }
+    volFiles, err := filepath.Glob(filepath.Join(dir, "Vol_*", "*."+format))
+    if err != nil {
+        return err
+    }
+    var inputs []string
+    for _, file := range append(files, volFiles...) {
+        if file != outFile {
+            inputs = append(inputs, file)
+        }
+    }
+    if len(inputs) == 0 {
+        return fmt.Errorf("no %s file found in %s", format, dir)
+    }
+    // Vol_<n> folders only hold later episodes than the files before them
+    // when -eps-per-volume was used, the episode number decides
+    type mergeInput struct {
+        path  string
+        order float64
+        part  int
+    }
+    var ordered []mergeInput
+    byKey := make(map[mergeInput]string)
+    for _, input := range inputs {
+        name := filepath.Base(input)
+        order, err := mergeOrder(name)
+        if err != nil {
+            return err
+        }
+        suffix := mergeSuffixRe.FindStringSubmatch(name)
+        if suffix[1] != "" {
+            // the full download of the same episodes sits next to it
+            log.Printf("merge: skipping preview %s", input)
+            continue
+        }
+        part := 0
+        if suffix[2] != "" {
+            part, _ = strconv.Atoi(suffix[2])
+        }
+        key := mergeInput{order: order, part: part}
+        if previous, ok := byKey[key]; ok {
+            return fmt.Errorf("%s and %s both start at the same episode, move one of them before merging", previous, input)
+        }
+        byKey[key] = input
+        ordered = append(ordered, mergeInput{input, order, part})
+    }
+    if len(ordered) == 0 {
+        return fmt.Errorf("no %s file found in %s", format, dir)
+    }
+    sort.Slice(ordered, func(i, j int) bool {
+        a, b := ordered[i], ordered[j]
+        if a.order != b.order {
+            return a.order < b.order
+        }
+        return a.part < b.part
+    })
+    inputs = inputs[:0]
+    for _, input := range ordered {
+        inputs = append(inputs, input.path)
+    }
+
+    comic := getComicFile(format, dir)
+    saved := false
+    defer func() {
+        if !saved {
+            comic.abort()
+        }
+    }()
+    for i, input := range inputs {
+        log.Printf("merging %s", input)
+        switch c := comic.(type) {
+        case *PDFComicFile:
+            err = c.addPDFPages(input)
+        case *CBZComicFile:
+            err = c.addCBZPages(input, i == 0)
+        }
+        if err != nil {
+            return fmt.Errorf("%s: %v", input, err)
+        }
+    }
+    if err := comic.save(outFile); err != nil {
+        return err
+    }
+    saved = true
+    log.Printf("merged %d files to %s", len(inputs), outFile)
+    return nil
+}
+
+// checkCBZ reads every entry of a cbz, which verifies their checksums, and
+// returns its number of pages
+func checkCBZ(file string) (int, error) {
+    r, err := zip.OpenReader(file)
+    if err != nil {
+        return 0, err
+    }
+    defer r.Close()
+    pages := 0
+    for _, f := range r.File {
+        rc, err := f.Open()
+        if err != nil {
+            return pages, fmt.Errorf("%s: %v", f.Name, err)
+        }
+        data, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            return pages, fmt.Errorf("%s: %v", f.Name, err)
+        }
+        if strings.HasSuffix(f.Name, ".jpg") {
+            if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+                return pages, fmt.Errorf("%s: %v", f.Name, err)
+            }
+            pages++
+        }
+    }
+    return pages, nil
 }
 
-
-
-
-func saveBatch(pool *gopool.GoPool,title string, lang string, opts Opts, episodeBatch EpisodeBatch, totalEpisodes int)  {
-    defer pool.Done()
+// checkPDF parses the pdf structure and returns its number of pages
+func checkPDF(file string) (pages int, err error) {
     defer func() {
-        if err := recover(); err != nil {
-            log.Printf("Recovered: %v", err)
+        // gofpdi panics on broken files
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
         }
     }()
-    var err error
-
-    outFile := fmt.Sprintf("webtoon/%s/%s/%s.%s", title, lang, episodeBatch.title, opts.format)
-
-    _, fileExist := os.Stat(outFile);
-    if (!*FileVerify ||  fileExist != nil){
-
-        comicFile := getComicFile(opts.format)
-            for idx, imgLink := range episodeBatch.imgLinks {
-                if strings.Contains(imgLink, ".gif") {
-                    fmt.Println(fmt.Sprintf("WARNING: skipping gif %s", imgLink))
-                    continue
-                }
-                err := comicFile.addImage(fetchImage(imgLink))
-                if err != nil {
-                    println("********************")
-                    panic(err.Error())
-                }
+    importer := gofpdi.NewImporter()
+    importer.SetSourceFile(file)
+    return importer.GetNumPages(), nil
+}
 
-                log.Printf(
-                        "Title: %s saving episodes %d through %d of %d: added page %d/%d",
-                        title,
-                        episodeBatch.minEp,
-                        episodeBatch.maxEp,
-                        totalEpisodes,
-                        idx+1,
-                        len(episodeBatch.imgLinks),
-                    )
+// validateFolder checks every cbz and pdf under dir and, with the database,
+// flags webtoons with a last_chapter but no file, returns the suspect count
+func validateFolder(db *sql.DB, dir string) int {
+    checked := 0
+    suspect := 0
+    withFiles := make(map[string]bool)
+    err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        ext := filepath.Ext(path)
+        if d.IsDir() || (ext != ".cbz" && ext != ".pdf") {
+            return nil
+        }
+        checked++
+        var pages int
+        if ext == ".cbz" {
+            pages, err = checkCBZ(path)
+        } else {
+            pages, err = checkPDF(path)
+        }
+        if err == nil && pages == 0 {
+            err = errors.New("no page")
+        }
+        if err != nil {
+            suspect++
+            fmt.Println(fmt.Sprintf("SUSPECT %s: %v", path, err))
+            return nil
+        }
+        log.Printf("OK %s: %d pages", path, pages)
+        for parent := filepath.Dir(path); parent != "." && parent != "/"; parent = filepath.Dir(parent) {
+            withFiles[parent] = true
+        }
+        return nil
+    })
+    if err != nil {
+        fmt.Println(err.Error())
+        suspect++
+    }
 
+    // the tachiyomi layout has no file to check
+    rows, err := db.Query("SELECT titre, lang, last_chapter FROM webtoon WHERE format != 'dir'")
+    if err == nil {
+        defer rows.Close()
+        for rows.Next() {
+            var titre, lang string
+            var lastChapter int
+            if rows.Scan(&titre, &lang, &lastChapter) != nil || lastChapter == 0 {
+                continue
             }
-            err = comicFile.save(outFile)
-            if err != nil {
-                println("********************")
-                panic(err.Error())
+            folder := filepath.Join("webtoon", titre, lang)
+            rel, err := filepath.Rel(dir, folder)
+            if err != nil || strings.HasPrefix(rel, "..") {
+                continue
             }
-            log.Printf("saved to %s", outFile)
+            if !withFiles[filepath.Join(dir, rel)] {
+                suspect++
+                fmt.Println(fmt.Sprintf("SUSPECT %s: last_chapter %d in database but no valid file", folder, lastChapter))
+            }
+        }
     }
+    fmt.Println(fmt.Sprintf("%d files checked, %d suspect", checked, suspect))
+    return suspect
 }
 
-func GetWebtoon(db *sql.DB, opts Opts)(error){
-    titre,lang,err := getWebtoonTitle (opts)
-
-    if err != nil {
-        panic(err)
-    }
+// Manifest is the -manifest file, the image links scraped for a series so
+// the download can be run again without scraping
+type Manifest struct {
+    URL        string          `json:"url"`
+    EpsPerFile int             `json:"epsPerFile"`
+    Latest     int             `json:"latest"`
+    Batches    []ManifestBatch `json:"batches"`
+}
 
-    outDirectory := fmt.Sprintf("webtoon/%s/%s/", titre, lang)
-    os.MkdirAll(outDirectory,0755)
+type ManifestBatch struct {
+    Title    string            `json:"title"`
+    MinEp    int               `json:"minEp"`
+    MaxEp    int               `json:"maxEp"`
+    Episodes []ManifestEpisode `json:"episodes"`
+    // episodes whose links could not be scraped
+    Skipped  []int             `json:"skipped,omitempty"`
+    // decimal part of each episode in Skipped
+    SkippedMinors []string     `json:"skippedMinors,omitempty"`
+}
 
-    episodeBatches,err := getEpisodeBatches(opts.url, opts.minEp, opts.maxEp, opts.epsPerFile)
+type ManifestEpisode struct {
+    No     int      `json:"no"`
+    // decimal part of bonus episodes, see episodeNumber
+    Minor  string   `json:"minor,omitempty"`
+    Title  string   `json:"title,omitempty"`
+    Note   string   `json:"note,omitempty"`
+    // page of the episode, missing in manifests of older versions
+    URL    string   `json:"url,omitempty"`
+    Images []string `json:"images"`
+}
 
-    if err != nil {
-        panic(err)
+func newManifest(url string, epsPerFile int, latest int, episodeBatches []EpisodeBatch) Manifest {
+    manifest := Manifest{URL: url, EpsPerFile: epsPerFile, Latest: latest}
+    for _, episodeBatch := range episodeBatches {
+        batch := ManifestBatch{Title: episodeBatch.title, MinEp: episodeBatch.minEp, MaxEp: episodeBatch.maxEp, Skipped: episodeBatch.skipped, SkippedMinors: episodeBatch.skippedMinors}
+        page := 0
+        for i, epNo := range episodeBatch.episodeNos {
+            batch.Episodes = append(batch.Episodes, ManifestEpisode{
+                No:     epNo,
+                Minor:  episodeBatch.minor(i),
+                Title:  episodeBatch.titles[i],
+                Note:   episodeBatch.notes[i],
+                URL:    episodeBatch.link(i),
+                Images: episodeBatch.imgLinks[page : page+episodeBatch.pageCounts[i]],
+            })
+            page += episodeBatch.pageCounts[i]
+        }
+        manifest.Batches = append(manifest.Batches, batch)
     }
+    return manifest
+}
 
-    last_episode :=0
-
-    totalPages := 0
-    for _, episodeBatch := range episodeBatches {
-        totalPages += len(episodeBatch.imgLinks)
+// episodeBatches returns the batches of the manifest overlapping minEp to maxEp
+func (m Manifest) episodeBatches(minEp int, maxEp int) []EpisodeBatch {
+    var episodeBatches []EpisodeBatch
+    for _, batch := range m.Batches {
+        if batch.MaxEp < minEp || batch.MinEp > maxEp {
+            continue
+        }
+        episodeBatch := EpisodeBatch{title: batch.Title, minEp: batch.MinEp, maxEp: batch.MaxEp, skipped: batch.Skipped, skippedMinors: batch.SkippedMinors}
+        for _, episode := range batch.Episodes {
+            episodeBatch.imgLinks = append(episodeBatch.imgLinks, episode.Images...)
+            episodeBatch.notes = append(episodeBatch.notes, episode.Note)
+            episodeBatch.episodeNos = append(episodeBatch.episodeNos, episode.No)
+            episodeBatch.minors = append(episodeBatch.minors, episode.Minor)
+            episodeBatch.titles = append(episodeBatch.titles, episode.Title)
+            episodeBatch.pageCounts = append(episodeBatch.pageCounts, len(episode.Images))
+            episodeBatch.links = append(episodeBatch.links, episode.URL)
+        }
+        episodeBatches = append(episodeBatches, episodeBatch)
     }
-    totalEpisodes := episodeBatches[len(episodeBatches)-1].maxEp - episodeBatches[0].minEp + 1
-    //fmt.Println(fmt.Sprintf("found %d total image links across %d episodes", totalPages, totalEpisodes))
-    //fmt.Println(fmt.Sprintf("saving into %d files with max of %d episodes per file", len(episodeBatches), opts.epsPerFile))
+    return episodeBatches
+}
 
-    pool := gopool.NewPool(*EpisodeGoroutine)
+// getManifestBatches is getEpisodeBatches going through the -manifest file,
+// read when it exists, otherwise written once scraping is done and before
+// any image is downloaded
+func getManifestBatches(file string, opts Opts) ([]EpisodeBatch, int, error) {
+    data, err := os.ReadFile(file)
+    if err == nil {
+        var manifest Manifest
+        if err := json.Unmarshal(data, &manifest); err != nil {
+            return nil, 0, fmt.Errorf("manifest %s: %w", file, err)
+        }
+        if manifest.URL != opts.url || manifest.EpsPerFile != opts.epsPerFile {
+            return nil, 0, fmt.Errorf("manifest %s was written for %s with eps-per-file=%d, remove it to scrape again", file, manifest.URL, manifest.EpsPerFile)
+        }
+        episodeBatches := manifest.episodeBatches(opts.minEp, opts.maxEp)
+        opts.logger.Printf("read %d batches from manifest %s", len(episodeBatches), file)
+        if len(episodeBatches) == 0 {
+            return nil, manifest.Latest, errNoEpisode
+        }
+        return episodeBatches, manifest.Latest, nil
+    }
+    if !os.IsNotExist(err) {
+        return nil, 0, err
+    }
 
-//    ctx, cancel := context.WithCancel(context.Background())
-//    defer cancel() // Make sure it's called to release resources even if no errors
+    episodeBatches, latest, err := getEpisodeBatches(opts.url, opts.minEp, opts.maxEp, opts.epsPerFile, opts.latestOnly, listCacheFile(opts), opts.logger)
+    if err != nil {
+        return episodeBatches, latest, err
+    }
+    data, err = json.MarshalIndent(newManifest(opts.url, opts.epsPerFile, latest, episodeBatches), "", "  ")
+    if err != nil {
+        return nil, 0, err
+    }
+    if err := os.WriteFile(file, data, 0644); err != nil {
+        return nil, 0, err
+    }
+    opts.logger.Printf("wrote %d batches to manifest %s", len(episodeBatches), file)
+    return episodeBatches, latest, nil
+}
 
+//print image links grouped by episode, e.g. to feed wget or aria2c
+func printLinks(episodeBatches []EpisodeBatch) {
     for _, episodeBatch := range episodeBatches {
-        pool.Add(1)
-        go saveBatch(pool,titre, lang, opts , episodeBatch, totalEpisodes )
+        page := 0
+        for i, epNo := range episodeBatch.episodeNos {
+            fmt.Println(fmt.Sprintf("# episode %s", episodeLabel(epNo, episodeBatch.minor(i), 0)))
+            for _, imgLink := range episodeBatch.imgLinks[page : page+episodeBatch.pageCounts[i]] {
+                fmt.Println(imgLink)
+            }
+            page += episodeBatch.pageCounts[i]
+        }
     }
-    pool.Wait()
-    last_episode=episodeBatches[len(episodeBatches)-1].maxEp
-
-    request := fmt.Sprintf(
-        "insert or replace into webtoon(titre,lang,url,last_chapter,epsPerFile,format) values ('%s','%s', '%s', %d,%d,'%s')",
-        titre,
-        lang,
-        opts.url,
-        last_episode,
-        opts.epsPerFile,
-        opts.format)
-    log.Printf(request)
+}
 
-    _, err = db.Exec(request)
+func doctorStep(name string, err error, detail string) bool {
     if err != nil {
-        panic(err)
+        fmt.Println(fmt.Sprintf("FAIL %s: %v", name, err))
+        return false
     }
-    return nil
+    fmt.Println(fmt.Sprintf("PASS %s: %s", name, detail))
+    return true
 }
 
+// trimmed copies of the pages the scraping patterns were written against,
+// see selfCheck
+const sampleListPage = `<div class="detail_lst"><ul>
+<li><a href="https://www.webtoons.com/en/fantasy/sample/episode-2/viewer?title_no=1&episode_no=2"><span class="subj"><span>[Season 1] Episode 2</span></span></a></li>
+<li><a href="https://www.webtoons.com/en/fantasy/sample/episode-1/viewer?title_no=1&episode_no=1"><span class="subj"><span>Episode 1</span></span></a></li>
+</ul></div>
+<div class="paginate"><a href="/en/fantasy/sample/list?title_no=1&page=1">1</a><a href="/en/fantasy/sample/list?title_no=1&page=2">2</a></div>`
 
-func GetWebtoonBatch(pool *gopool.GoPool,db *sql.DB,opts Opts)(){
-    defer pool.Done()
-    defer func() {
-        if err := recover(); err != nil {
-            log.Printf("Recovered: %v", err)
-        }
-    }()
-    GetWebtoon(db,opts)
+const sampleEpisodePage = `<h1 class="subj_episode" title="Episode 1">Episode 1</h1>
+<div class="viewer_lst"><div class="viewer_img _img_viewer_area">
+<img src="https://webtoons-static.pstatic.net/image/bg_transparency.png" data-url="https://webtoon-phinf.pstatic.net/sample/001.jpg?type=q90">
+<img src="https://webtoons-static.pstatic.net/image/bg_transparency.png" data-url="https://webtoon-phinf.pstatic.net/sample/002.jpg?type=q90">
+</div></div>`
 
-}
+const sampleOzPage = "viewerOptions: {\n        // 필수항목\n        containerId: '#ozViewer',\n        documentURL: 'https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=1&hashValue=0',\n" +
+    "motiontoonParam: {\n    pathRuleParam: {\n        stillcut: 'https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/{=filename}?type=q70',\n"
 
-func GetWebtoons(db *sql.DB, opts Opts)(){
+// selfCheck runs the scraping patterns against the embedded samples, a
+// failure means the parsing no longer reads the page format it was written
+// for and scrapes would fail deep in a run
+func selfCheck() []string {
+    var failures []string
+    episodes, lastPage, err := parseEpisodeLinks(soup.HTMLParse(sampleListPage), log.New(io.Discard, "", 0))
+    if err != nil {
+        failures = append(failures, fmt.Sprintf("list page: %v", err))
+    } else if len(episodes) != 2 || lastPage != 2 {
+        failures = append(failures, fmt.Sprintf("list page: found %d episodes and %d pages, want 2 and 2", len(episodes), lastPage))
+    } else if episodeNo(episodes[0].url) != 2 || episodes[0].season != 1 {
+        failures = append(failures, fmt.Sprintf("episode number: read episode %d of season %d from %s", episodeNo(episodes[0].url), episodes[0].season, episodes[0].url))
+    }
+    if episodeNo("https://www.webtoons.com/en/fantasy/sample/episode-12/viewer") != 12 {
+        failures = append(failures, "episode number: not read from the url path")
+    }
 
-    sqlStmt := "SELECT url,last_chapter,epsPerFile,format FROM webtoon ";
+    doc := soup.HTMLParse(sampleEpisodePage)
+    imgLinks, oz, err := parseImgLinks(doc)
+    if err != nil || oz || len(imgLinks) != 2 || !strings.HasSuffix(imgLinks[0], "001.jpg?type=q90") {
+        failures = append(failures, fmt.Sprintf("episode images: found %v (oz %v, error %v), want the 2 data-url links", imgLinks, oz, err))
+    }
+    if title := parseEpisodeTitle(doc); title != "Episode 1" {
+        failures = append(failures, fmt.Sprintf("episode title: found %q", title))
+    }
 
-    rows, err := db.Query(sqlStmt)
-    if err != nil {
-        log.Printf("ERROR %q: %s\n", err, sqlStmt)
+    if matches := ozDocumentURLRe.FindStringSubmatch(sampleOzPage); len(matches) != 2 || !strings.Contains(matches[1], "motiontoonJson.json") {
+        failures = append(failures, "oz viewer: documentURL not found")
+    }
+    if matches := ozPathRuleRe.FindStringSubmatch(sampleOzPage); len(matches) != 2 || !strings.Contains(matches[1], "{=filename}") {
+        failures = append(failures, "oz viewer: pathRule not found")
+    }
+    return failures
+}
 
-    }else{
-        var webtoons []Opts
-        var url string
-        var format string
-        var epsPerFile int
-        var last_chapter int
-        defer rows.Close()
+// runSelfCheck prints the result of selfCheck, return the exit code
+func runSelfCheck() int {
+    failures := selfCheck()
+    for _, failure := range failures {
+        fmt.Println("FAIL " + failure)
+    }
+    if len(failures) > 0 {
+        fmt.Println("the scraping patterns don't match the sample pages, downloads will likely fail")
+        return 1
+    }
+    fmt.Println("OK   scraping patterns match the sample pages")
+    return 0
+}
 
-        for rows.Next() {
-            err = rows.Scan( &url, &last_chapter,&epsPerFile,&format)
-            if err != nil {
-                println(url)
-                log.Fatal(err) //*
-            }
-            opts.url = url
-            opts.minEp=last_chapter
-            //by default download until the end
-            opts.maxEp=last_chapter+opts.maxEp
-            if !*confOverride {
-                opts.epsPerFile=epsPerFile
-                opts.format=format
-            }
-            webtoons = append(webtoons, opts)
+//check list page, episode page and image cdn for url, return the exit code
+func runDoctor(url string) int {
+    episodeURL := url
+    if !strings.Contains(url, "/viewer") {
+        episodes, _, err := getEpisodeLinksForPage(context.Background(), url, log.Default())
+        if err == nil && len(episodes) == 0 {
+            err = errors.New("no episode found")
         }
-
-        if *MaxWebtoonGoroutine {
-            *WebtoonGoroutine = len(webtoons)
+        if !doctorStep("list page", err, fmt.Sprintf("found %d episodes", len(episodes))) {
+            return 1
         }
-        pool := gopool.NewPool(*WebtoonGoroutine)
+        episodeURL = episodes[len(episodes)-1].url
+        for _, episode := range episodes {
+            if episodeNo(episode.url) < episodeNo(episodeURL) {
+                episodeURL = episode.url
+            }
+        }
+    }
 
-        for _, opts := range webtoons {
-            defer pool.Done()
-            pool.Add(1)
-            go GetWebtoonBatch(pool,db,opts)
+    resp, err := getPage(episodeURL)
+    if !doctorStep("episode page", err, episodeURL) {
+        return 1
+    }
+    imgLinks, oz, err := parseImgLinks(soup.HTMLParse(resp))
+    if err == nil && len(imgLinks) == 0 {
+        err = errors.New("no image link found")
+    }
+    path := "normal"
+    if oz {
+        path = "oz"
+    }
+    if !doctorStep("image links", err, fmt.Sprintf("found %d images using %s path", len(imgLinks), path)) {
+        return 1
+    }
 
-        }
-        pool.Wait()
-        //bug did not exit function when finish
-        os.Exit(0)
+    img, err := downloadImage(imgLinks[0])
+    if err == nil {
+        _, _, err = image.DecodeConfig(bytes.NewReader(img))
+    }
+    if !doctorStep("image cdn", err, fmt.Sprintf("fetched %d bytes from %s", len(img), imgLinks[0])) {
+        return 1
     }
+    return 0
 }
 
 //open database create table if did not exist
 func openDatabse(file string)(*sql.DB){
+    if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+        log.Fatalf("could not create database directory: %v", err)
+    }
+    // sql.Open is lazy, check the file can be written to fail early and clearly
+    f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        fmt.Println(fmt.Sprintf("database %s is not writable: %v", file, err))
+        log.Fatalf("database %s is not writable: %v", file, err)
+    }
+    f.Close()
+
     db, err := sql.Open("sqlite3", file)
 
     if err != nil {
@@ -704,7 +5035,7 @@ func openDatabse(file string)(*sql.DB){
 
     if NotExist {
         log.Printf("create table")
-        sqlStmt := "create table webtoon (titre text, lang text,url,text,last_chapter integer,epsPerFile integer,format text, PRIMARY KEY(titre,lang));"
+        sqlStmt := "create table webtoon (titre text, lang text,url,text,last_chapter integer,epsPerFile integer,format text,latest_known integer default 0,status text, PRIMARY KEY(titre,lang));"
 
         _, err := db.Exec(sqlStmt)
         if err != nil {
@@ -712,9 +5043,217 @@ func openDatabse(file string)(*sql.DB){
             log.Fatal(err) //*
         }
     }
+
+    // databases created before latest_known was added
+    var latestKnownColumn int
+    sqlStmt = "SELECT count(*) FROM pragma_table_info('webtoon') WHERE name='latest_known'"
+    err = db.QueryRow(sqlStmt).Scan(&latestKnownColumn)
+    if err != nil {
+        println("ERROR %q: %s\n", err, sqlStmt)
+        log.Fatal(err) //*
+    }
+    if latestKnownColumn == 0 {
+        log.Printf("add latest_known column")
+        sqlStmt = "alter table webtoon add column latest_known integer default 0;"
+        _, err = db.Exec(sqlStmt)
+        if err != nil {
+            println("ERROR %q: %s\n", err, sqlStmt)
+            log.Fatal(err) //*
+        }
+    }
+
+    // databases created before status was added, 'gone' for series whose
+    // url answered 404
+    var statusColumn int
+    sqlStmt = "SELECT count(*) FROM pragma_table_info('webtoon') WHERE name='status'"
+    err = db.QueryRow(sqlStmt).Scan(&statusColumn)
+    if err != nil {
+        println("ERROR %q: %s\n", err, sqlStmt)
+        log.Fatal(err) //*
+    }
+    if statusColumn == 0 {
+        log.Printf("add status column")
+        sqlStmt = "alter table webtoon add column status text;"
+        _, err = db.Exec(sqlStmt)
+        if err != nil {
+            println("ERROR %q: %s\n", err, sqlStmt)
+            log.Fatal(err) //*
+        }
+    }
+
+    // page count of each downloaded episode, see -check-page-counts
+    sqlStmt = "create table if not exists episode (titre text, lang text, episode_no integer, pages integer, PRIMARY KEY(titre,lang,episode_no));"
+    _, err = db.Exec(sqlStmt)
+    if err != nil {
+        println("ERROR %q: %s\n", err, sqlStmt)
+        log.Fatal(err) //*
+    }
     return (db)
 }
 
+// subcommands, their arguments are the shared flags of parseFlags on a flag
+// set of their own, implies holds the flat flags they stand for
+var commands = []struct {
+    name    string
+    args    string
+    summary string
+    implies []string
+}{
+    {"download", "[flags] <url>", "Download a series to pdf, cbz or folders", nil},
+    {"sync", "[flags]", "Download the new episodes of every series of the database", []string{"-db"}},
+    {"add", "[flags] <url>", "Register a series for sync with its -format, -eps-per-file and -min-ep", []string{"-add"}},
+    {"list", "[flags]", "List the series of the database", nil},
+    {"remove", "[flags] <url or series>", "Remove a series from the database, its files are kept", nil},
+    {"watch", "[flags]", "Run sync again and again, waiting -every between runs", []string{"-db"}},
+    {"doctor", "[flags] <url>", "Check the list page, episode page and image cdn of a url without saving anything", nil},
+}
+
+// time between two sync runs of watch
+var watchEvery time.Duration
+
+func printCommands(out io.Writer) {
+    fmt.Fprintln(out, "Usage: webtoon-dl <command> [flags] [arguments]")
+    fmt.Fprintln(out, "\nCommands:")
+    for _, command := range commands {
+        fmt.Fprintf(out, "  %-9s %s\n", command.name, command.summary)
+    }
+    fmt.Fprintln(out, "\nRun webtoon-dl <command> -h for the flags of a command.")
+    fmt.Fprintln(out, "webtoon-dl [flags] <url> still works as download, with the flags of older versions.")
+}
+
+// parseCommand parses the subcommand in args[1] and its flags, "" is
+// returned for the flat flags of older versions. list and remove only need
+// the database and are run here
+func parseCommand(args []string) (string, Opts) {
+    if len(args) < 2 || args[1] == "-h" || args[1] == "-help" || args[1] == "--help" || args[1] == "help" {
+        printCommands(os.Stdout)
+        if len(args) < 2 {
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+    index := -1
+    for i, command := range commands {
+        if command.name == args[1] {
+            index = i
+        }
+    }
+    if index < 0 {
+        flag.CommandLine.Usage = func() {
+            printCommands(flag.CommandLine.Output())
+            fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+            flag.PrintDefaults()
+        }
+        return "", parseOpts(args)
+    }
+    command := commands[index]
+    rest := args[2:]
+
+    fs := flag.NewFlagSet("webtoon-dl "+command.name, flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: webtoon-dl %s %s\n\n%s\n\nFlags:\n", command.name, command.args, command.summary)
+        fs.PrintDefaults()
+    }
+
+    switch command.name {
+    case "list":
+        dbFile := fs.String("db-file", "./database.db", "Path of the SQLite database")
+        fs.Parse(rest)
+        if fs.NArg() != 0 {
+            fs.Usage()
+            os.Exit(2)
+        }
+        db := openDatabse(*dbFile)
+        code := listWebtoons(db)
+        db.Close()
+        os.Exit(code)
+    case "remove":
+        dbFile := fs.String("db-file", "./database.db", "Path of the SQLite database")
+        lang := fs.String("lang", "", "Only remove the series in this language when a series name is given")
+        fs.Parse(rest)
+        if fs.NArg() != 1 {
+            fs.Usage()
+            os.Exit(2)
+        }
+        db := openDatabse(*dbFile)
+        code := removeWebtoon(db, fs.Arg(0), *lang)
+        db.Close()
+        os.Exit(code)
+    case "watch":
+        fs.DurationVar(&watchEvery, "every", 6*time.Hour, "Time to wait after a sync run before the next one")
+    }
+
+    needsURL := command.name == "download" || command.name == "add" || command.name == "doctor"
+    if needsURL && (len(rest) == 0 || strings.HasPrefix(rest[len(rest)-1], "-")) {
+        fs.Usage()
+        os.Exit(2)
+    }
+    flags := append([]string{args[0]}, command.implies...)
+    if command.name == "doctor" {
+        // -doctor takes the url as its value, the url stays last for parseFlags
+        flags = append(append(flags, rest[:len(rest)-1]...), "-doctor", rest[len(rest)-1], rest[len(rest)-1])
+    } else {
+        flags = append(flags, rest...)
+    }
+    opts := parseFlags(fs, flags)
+    if needsURL && fs.NArg() != 1 || !needsURL && fs.NArg() != 0 {
+        fs.Usage()
+        os.Exit(2)
+    }
+    return command.name, opts
+}
+
+// listWebtoons prints the series of the database, return the exit code
+func listWebtoons(db *sql.DB) int {
+    rows, err := db.Query("SELECT titre, lang, last_chapter, epsPerFile, format, IFNULL(status, ''), url FROM webtoon ORDER BY titre, lang")
+    if err != nil {
+        fmt.Println(err.Error())
+        return 1
+    }
+    defer rows.Close()
+    fmt.Printf("%-30s %-5s %6s %6s %-8s %-6s %s\n", "SERIES", "LANG", "LAST", "EPS", "FORMAT", "STATUS", "URL")
+    for rows.Next() {
+        var titre, lang, format, status, url string
+        var lastChapter, epsPerFile int
+        if err := rows.Scan(&titre, &lang, &lastChapter, &epsPerFile, &format, &status, &url); err != nil {
+            fmt.Println(err.Error())
+            return 1
+        }
+        fmt.Printf("%-30s %-5s %6d %6d %-8s %-6s %s\n", titre, lang, lastChapter, epsPerFile, format, status, url)
+    }
+    if err := rows.Err(); err != nil {
+        fmt.Println(err.Error())
+        return 1
+    }
+    return 0
+}
+
+// removeWebtoon deletes the rows of a series given by url or by name,
+// return the exit code
+func removeWebtoon(db *sql.DB, series string, lang string) int {
+    titre := series
+    if strings.Contains(series, "://") {
+        var err error
+        titre, lang, err = getWebtoonTitle(Opts{url: series, logger: log.Default()})
+        if err != nil {
+            fmt.Println(err.Error())
+            return 1
+        }
+    }
+    result, err := db.Exec("DELETE FROM webtoon WHERE titre = ? AND (? = '' OR lang = ?)", titre, lang, lang)
+    if err != nil {
+        fmt.Println(err.Error())
+        return 1
+    }
+    removed, _ := result.RowsAffected()
+    if removed == 0 {
+        fmt.Printf("%s is not in the database\n", series)
+        return 1
+    }
+    fmt.Printf("removed %d series, the downloaded files are kept\n", removed)
+    return 0
+}
+
 func main() {
     logFile, err := os.OpenFile("log", os.O_RDWR | os.O_CREATE, 0666)
     if err != nil {
@@ -722,21 +5261,125 @@ func main() {
     }
     defer logFile.Close()
 
-    opts := parseOpts(os.Args)
+    command, opts := parseCommand(os.Args)
+
+    if *SelfCheck {
+        os.Exit(runSelfCheck())
+    }
+    // cheap and offline, a broken pattern shows before anything is fetched
+    if failures := selfCheck(); len(failures) > 0 {
+        log.Printf("WARNING: scraping self-check failed: %s", strings.Join(failures, "; "))
+        fmt.Fprintln(os.Stderr, "WARNING: scraping self-check failed, run with -self-check for details:", strings.Join(failures, "; "))
+    }
+
+    if *Doctor != "" {
+        os.Exit(runDoctor(*Doctor))
+    }
+
+    if *Merge != "" {
+        if strings.Contains(opts.format, ",") {
+            fmt.Println("-merge needs a single -format")
+            os.Exit(1)
+        }
+        err := mergeFolder(*Merge, opts.format)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
+    if *PrintLinks {
+        episodeBatches, _, err := getEpisodeBatches(opts.url, opts.minEp, opts.maxEp, opts.epsPerFile, opts.latestOnly, listCacheFile(opts), opts.logger)
+        if err != nil {
+            fmt.Println(err.Error())
+            os.Exit(1)
+        }
+        printLinks(episodeBatches)
+        os.Exit(0)
+    }
 
     if !*NoLog {
        log.SetOutput(logFile)
     }
 
 
-    db:=openDatabse("./database.db")
+    db:=openDatabse(*DBFile)
     defer db.Close()
 
-    if *database {
-        GetWebtoons(db,opts)
+    if *ValidateOnly != "" {
+        if validateFolder(db, *ValidateOnly) > 0 {
+            db.Close()
+            os.Exit(1)
+        }
+        return
+    }
 
+    if *Add {
+        if err := addWebtoon(db, opts); err != nil {
+            fmt.Println(err.Error())
+            db.Close()
+            os.Exit(1)
+        }
+        return
+    }
 
+    failedWebtoons := 0
+    if command == "watch" {
+        for {
+            GetWebtoons(db, opts)
+            if runCtx.Err() != nil {
+                break
+            }
+            log.Printf("watch: next sync in %s", watchEvery)
+            fmt.Printf("watch: next sync in %s\n", watchEvery)
+            time.Sleep(watchEvery)
+        }
+    }else if *database {
+        failedWebtoons = GetWebtoons(db,opts)
     }else{
-        GetWebtoon(db,opts)
+        if *OnlyNew {
+            lastChapter, found, err := getLastChapter(db, opts)
+            if err != nil {
+                fmt.Println(err.Error())
+                os.Exit(1)
+            }
+            if found {
+                log.Printf("only new episodes from %d", lastChapter)
+                opts = incrementalOpts(opts, lastChapter)
+            } else {
+                log.Printf("%s not in database, downloading from episode %d", opts.url, opts.minEp)
+            }
+        }
+        err = func() (err error) {
+            defer func() {
+                if r := recover(); r != nil {
+                    err = fmt.Errorf("%v", r)
+                }
+            }()
+            return GetWebtoon(db,opts)
+        }()
+        if err != nil {
+            log.Printf("ERROR %v", err)
+            fmt.Println(err.Error())
+            failedWebtoons = 1
+        }
+    }
+
+    // one line for scripts, on stderr when stdout carries the file
+    result := fmt.Sprintf("RESULT ok=%d failed=%d", atomic.LoadInt64(&episodesSaved), atomic.LoadInt64(&episodesFailed))
+    log.Println(result)
+    if *Output == "-" {
+        fmt.Fprintln(os.Stderr, result)
+    } else {
+        fmt.Println(result)
+    }
+    if firstError.err != nil {
+        log.Printf("ERROR -fail-fast: %v", firstError.err)
+        fmt.Fprintln(os.Stderr, "fail-fast:", firstError.err)
+    }
+    if failedWebtoons > 0 || atomic.LoadInt64(&episodesFailed) > 0 || firstError.err != nil {
+        db.Close()
+        os.Exit(1)
     }
 }
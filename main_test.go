@@ -0,0 +1,1265 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "errors"
+    "flag"
+    "fmt"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/anaskhan96/soup"
+)
+
+const testListURL = "https://www.webtoons.com/en/fantasy/sample/list?title_no=1"
+
+// fakeSite serves the body of each url it knows, 404 for the others.
+// Bodies of .jpg urls are served as images, the others as html
+type fakeSite map[string]string
+
+func (s fakeSite) RoundTrip(req *http.Request) (*http.Response, error) {
+    rec := httptest.NewRecorder()
+    if body, ok := s[req.URL.String()]; ok {
+        if strings.HasSuffix(req.URL.Path, ".jpg") {
+            rec.Header().Set("Content-Type", "image/jpeg")
+        } else {
+            rec.Header().Set("Content-Type", "text/html; charset=utf-8")
+        }
+        io.WriteString(rec, body)
+    } else {
+        rec.WriteHeader(http.StatusNotFound)
+    }
+    resp := rec.Result()
+    resp.Request = req
+    return resp, nil
+}
+
+// testFlags holds the default flag values every test starts from, parsed
+// once by TestMain
+var testFlags *flag.FlagSet
+
+func TestMain(m *testing.M) {
+    testFlags = parseTestDefaults()
+    os.Exit(m.Run())
+}
+
+func parseTestDefaults() *flag.FlagSet {
+    fs := flag.NewFlagSet("webtoon-dl", flag.ContinueOnError)
+    parseFlags(fs, []string{"webtoon-dl", testListURL})
+    return fs
+}
+
+// setupTest sends every request of the test to site
+func setupTest(t *testing.T, site fakeSite) *log.Logger {
+    t.Helper()
+    client := httpClient
+    httpClient = &http.Client{Transport: site}
+    t.Cleanup(func() { httpClient = client })
+    return log.New(io.Discard, "", 0)
+}
+
+// setTestFlag sets a flag for the test, its previous value is restored
+// when the test ends
+func setTestFlag(t *testing.T, name string, value string) {
+    t.Helper()
+    f := testFlags.Lookup(name)
+    if f == nil {
+        t.Fatalf("unknown flag %s", name)
+    }
+    previous := f.Value.String()
+    if err := f.Value.Set(value); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { f.Value.Set(previous) })
+}
+
+// parseTestFlags parses a command line like main does, which points the
+// flag globals at a new flag set, the defaults are back when the test ends
+func parseTestFlags(t *testing.T, args ...string) Opts {
+    t.Helper()
+    client := httpClient
+    t.Cleanup(func() {
+        testFlags = parseTestDefaults()
+        httpClient = client
+    })
+    return parseFlags(flag.NewFlagSet("webtoon-dl", flag.ContinueOnError), append(append([]string{"webtoon-dl"}, args...), testListURL))
+}
+
+const testOzPage = `<div id="ozViewer"></div>
+<script>
+    viewerOptions: {
+        // 필수항목
+        containerId: '#ozViewer',
+        documentURL: 'https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=1&hashValue=0',
+    },
+    motiontoonParam: {
+        pathRuleParam: {
+            stillcut: 'https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/{=filename}?type=q70',
+        }
+    }
+</script>`
+
+const testMotiontoonJSON = `{"assets": {"image": {"layer2": "b.png", "layer10": "c.png", "layer1": "a.png"}}}`
+
+func TestGetEpisodeLinksForPage(t *testing.T) {
+    pageURL := testListURL + "&page=1"
+    logger := setupTest(t, fakeSite{pageURL: sampleListPage})
+
+    episodes, last, err := getEpisodeLinksForPage(runCtx, pageURL, logger)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []EpisodeInfo{
+        {title: "[Season 1] Episode 2", url: "https://www.webtoons.com/en/fantasy/sample/episode-2/viewer?title_no=1&episode_no=2", season: 1},
+        {title: "Episode 1", url: "https://www.webtoons.com/en/fantasy/sample/episode-1/viewer?title_no=1&episode_no=1"},
+    }
+    if !reflect.DeepEqual(episodes, want) {
+        t.Errorf("episodes = %+v, want %+v", episodes, want)
+    }
+    if last != 2 {
+        t.Errorf("last page = %d, want 2", last)
+    }
+
+    if _, _, err := getEpisodeLinksForPage(runCtx, testListURL+"&page=9", logger); err == nil {
+        t.Error("missing page: expected an error")
+    }
+}
+
+func TestGetImgLinksForEpisode(t *testing.T) {
+    episodeURL := "https://www.webtoons.com/en/fantasy/sample/episode-1/viewer?title_no=1&episode_no=1"
+    setupTest(t, fakeSite{episodeURL: sampleEpisodePage})
+
+    imgLinks, _, title, err := getImgLinksForEpisode(episodeURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{
+        "https://webtoon-phinf.pstatic.net/sample/001.jpg?type=q90",
+        "https://webtoon-phinf.pstatic.net/sample/002.jpg?type=q90",
+    }
+    if !reflect.DeepEqual(imgLinks, want) {
+        t.Errorf("image links = %v, want %v", imgLinks, want)
+    }
+    if title != "Episode 1" {
+        t.Errorf("title = %q, want %q", title, "Episode 1")
+    }
+}
+
+func TestGetImgLinksForEpisodeOz(t *testing.T) {
+    episodeURL := "https://www.webtoons.com/en/fantasy/sample/episode-1/viewer?title_no=1&episode_no=1"
+    setupTest(t, fakeSite{
+        episodeURL: testOzPage,
+        "https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=1&hashValue=0": testMotiontoonJSON,
+    })
+
+    imgLinks, _, _, err := getImgLinksForEpisode(episodeURL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(imgLinks) != 3 {
+        t.Errorf("image links = %v, want the 3 oz images", imgLinks)
+    }
+}
+
+func TestWithImageQuality(t *testing.T) {
+    const signed = "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/a.png?x-expires=1700000000&type=q70&sig=ab%2Fcd%3D"
+    tests := []struct {
+        name    string
+        quality string
+        link    string
+        want    string
+    }{
+        {name: "signed", quality: "q90", link: signed, want: "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/a.png?x-expires=1700000000&type=q90&sig=ab%2Fcd%3D"},
+        {name: "signed original", quality: "original", link: signed, want: "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/a.png?x-expires=1700000000&sig=ab%2Fcd%3D"},
+        {name: "same quality", quality: "q70", link: signed, want: signed},
+        {name: "no query", quality: "q90", link: "https://cdn/a.png", want: "https://cdn/a.png?type=q90"},
+        {name: "no query original", quality: "original", link: "https://cdn/a.png#1", want: "https://cdn/a.png#1"},
+        {name: "only type", quality: "original", link: "https://cdn/a.png?type=q70", want: "https://cdn/a.png"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            setTestFlag(t, "image-quality", tt.quality)
+            if got := withImageQuality(tt.link); got != tt.want {
+                t.Errorf("withImageQuality = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestGetOzPageImgLinks(t *testing.T) {
+    tests := []struct {
+        name    string
+        page    string
+        quality string
+        want    []string
+        wantErr bool
+    }{
+        {
+            name: "layers in natural order",
+            page: testOzPage,
+            want: []string{
+                "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/a.png?type=q70",
+                "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/b.png?type=q70",
+                "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/c.png?type=q70",
+            },
+        },
+        {
+            name:    "requested quality",
+            page:    testOzPage,
+            quality: "q90",
+            want: []string{
+                "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/a.png?type=q90",
+                "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/b.png?type=q90",
+                "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/c.png?type=q90",
+            },
+        },
+        {
+            name:    "no documentURL",
+            page:    `<div id="ozViewer"></div>`,
+            wantErr: true,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            setupTest(t, fakeSite{
+                "https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=1&hashValue=0": testMotiontoonJSON,
+            })
+            if tt.quality != "" {
+                setTestFlag(t, "image-quality", tt.quality)
+            }
+            imgLinks, err := getOzPageImgLinks(soup.HTMLParse(tt.page))
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if !reflect.DeepEqual(imgLinks, tt.want) {
+                t.Errorf("image links = %v, want %v", imgLinks, tt.want)
+            }
+        })
+    }
+}
+
+// testListPage renders a list page of the episodes, given as url and title
+// pairs newest first like the site does
+func testListPage(episodes ...[2]string) string {
+    var b strings.Builder
+    b.WriteString(`<div class="detail_lst"><ul>`)
+    for _, episode := range episodes {
+        b.WriteString(`<li><a href="` + episode[0] + `"><span class="subj"><span>` + episode[1] + `</span></span></a></li>`)
+    }
+    b.WriteString(`</ul></div>`)
+    return b.String()
+}
+
+func testEpisodeURL(path string, episodeNo string) string {
+    url := "https://www.webtoons.com/en/fantasy/sample/" + path + "/viewer?title_no=1"
+    if episodeNo != "" {
+        url += "&episode_no=" + episodeNo
+    }
+    return url
+}
+
+func episodeTitles(episodes []EpisodeInfo) []string {
+    var titles []string
+    for _, episode := range episodes {
+        titles = append(titles, episode.title)
+    }
+    return titles
+}
+
+func TestGetAllEpisodeLinksUnnumbered(t *testing.T) {
+    page := testListPage(
+        [2]string{testEpisodeURL("episode-3", "3"), "Episode 3"},
+        [2]string{testEpisodeURL("special-b", ""), "Special B"},
+        [2]string{testEpisodeURL("episode-2", "2"), "Episode 2"},
+        [2]string{testEpisodeURL("special-a", ""), "Special A"},
+        [2]string{testEpisodeURL("episode-1", "1"), "Episode 1"},
+    )
+    tests := []struct {
+        name           string
+        skipUnnumbered bool
+        want           []string
+    }{
+        {
+            name: "unnumbered stay after their neighbour",
+            want: []string{"Episode 1", "Special A", "Episode 2", "Special B", "Episode 3"},
+        },
+        {
+            name:           "skip-unnumbered",
+            skipUnnumbered: true,
+            want:           []string{"Episode 1", "Episode 2", "Episode 3"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            // past the last page the site rerenders it
+            logger := setupTest(t, fakeSite{testListURL + "&page=1": page, testListURL + "&page=2": page})
+            setTestFlag(t, "skip-unnumbered", strconv.FormatBool(tt.skipUnnumbered))
+            episodes, err := getAllEpisodeLinks(runCtx, testListURL, "", logger)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := episodeTitles(episodes); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("episodes = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestGetAllEpisodeLinksPinned(t *testing.T) {
+    pinned := [2]string{testEpisodeURL("episode-1", "1"), "Episode 1"}
+    page1 := testListPage(
+        pinned,
+        [2]string{testEpisodeURL("episode-5", "5"), "Episode 5"},
+        [2]string{testEpisodeURL("episode-4", "4"), "Episode 4"},
+    )
+    page2 := testListPage(
+        pinned,
+        [2]string{testEpisodeURL("episode-3", "3"), "Episode 3"},
+        [2]string{testEpisodeURL("episode-2", "2"), "Episode 2"},
+    )
+    tests := []struct {
+        name         string
+        maxListPages int
+        want         []string
+    }{
+        {
+            name: "pinned episode repeated on every page",
+            want: []string{"Episode 1", "Episode 2", "Episode 3", "Episode 4", "Episode 5"},
+        },
+        {
+            name:         "max-list-pages",
+            maxListPages: 1,
+            want:         []string{"Episode 1", "Episode 4", "Episode 5"},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logger := setupTest(t, fakeSite{
+                testListURL + "&page=1": page1,
+                testListURL + "&page=2": page2,
+                testListURL + "&page=3": page2,
+            })
+            if tt.maxListPages > 0 {
+                setTestFlag(t, "max-list-pages", strconv.Itoa(tt.maxListPages))
+            }
+            episodes, err := getAllEpisodeLinks(runCtx, testListURL, "", logger)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := episodeTitles(episodes); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("episodes = %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestGetAllEpisodeLinksPinnedCache(t *testing.T) {
+    pinned := [2]string{testEpisodeURL("episode-1", "1"), "Episode 1"}
+    episode := func(n string) [2]string {
+        return [2]string{testEpisodeURL("episode-"+n, n), "Episode " + n}
+    }
+    cacheFile := filepath.Join(t.TempDir(), "episodes.json")
+    logger := setupTest(t, fakeSite{
+        testListURL + "&page=1": testListPage(pinned, episode("5"), episode("4")),
+        testListURL + "&page=2": testListPage(pinned, episode("3"), episode("2")),
+        testListURL + "&page=3": testListPage(pinned, episode("3"), episode("2")),
+    })
+    if _, err := getAllEpisodeLinks(runCtx, testListURL, cacheFile, logger); err != nil {
+        t.Fatal(err)
+    }
+
+    // a new episode is out, the pinned one stays on top
+    setupTest(t, fakeSite{
+        testListURL + "&page=1": testListPage(pinned, episode("6"), episode("5")),
+        testListURL + "&page=2": testListPage(pinned, episode("4"), episode("3")),
+        testListURL + "&page=3": testListPage(pinned, episode("2")),
+        testListURL + "&page=4": testListPage(pinned, episode("2")),
+    })
+    out := new(bytes.Buffer)
+    episodes, err := getAllEpisodeLinks(runCtx, testListURL, cacheFile, log.New(out, "", 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"Episode 1", "Episode 2", "Episode 3", "Episode 4", "Episode 5", "Episode 6"}
+    if got := episodeTitles(episodes); !reflect.DeepEqual(got, want) {
+        t.Errorf("episodes = %v, want %v", got, want)
+    }
+    if strings.Contains(out.String(), "scanning it again") || !strings.Contains(out.String(), "from "+cacheFile) {
+        t.Errorf("cache not used:\n%s", out)
+    }
+}
+
+func TestParseEpisodeLinksEntities(t *testing.T) {
+    tests := []struct {
+        name     string
+        subject  string
+        want     string
+        wantFile string
+    }{
+        {name: "ampersand", subject: "Tom &amp; Jerry", want: "Tom & Jerry", wantFile: "Tom & Jerry"},
+        {name: "apostrophe", subject: "It&#39;s over", want: "It's over", wantFile: "It's over"},
+        {name: "double encoded", subject: "It&amp;#39;s &amp;amp; done", want: "It&#39;s &amp; done", wantFile: "It&#39;s &amp; done"},
+        {name: "literal entity text", subject: "Tom &amp;amp; Jerry", want: "Tom &amp; Jerry", wantFile: "Tom &amp; Jerry"},
+        {name: "unsafe characters", subject: "What&#63; &lt;Part 1/2&gt;", want: "What? <Part 1/2>", wantFile: "What_ _Part 1_2_"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            page := testListPage([2]string{testEpisodeURL("episode-1", "1"), tt.subject})
+            episodes, _, err := parseEpisodeLinks(soup.HTMLParse(page), log.New(io.Discard, "", 0))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(episodes) != 1 {
+                t.Fatalf("episodes = %+v, want 1", episodes)
+            }
+            if episodes[0].title != tt.want {
+                t.Errorf("title = %q, want %q", episodes[0].title, tt.want)
+            }
+            if file := createTitle([]string{episodes[0].title}); file != tt.wantFile {
+                t.Errorf("file name = %q, want %q", file, tt.wantFile)
+            }
+        })
+    }
+}
+
+func TestParseEpisodeTitleEntities(t *testing.T) {
+    tests := []struct {
+        name string
+        page string
+        want string
+    }{
+        {name: "title attribute", page: `<h1 class="subj_episode" title="It&amp;#39;s over">x</h1>`, want: "It&#39;s over"},
+        {name: "text", page: `<h1 class="subj_episode">Tom &amp;amp; Jerry</h1>`, want: "Tom &amp; Jerry"},
+        {name: "og:title", page: `<meta property="og:title" content="It&#39;s &amp;amp; done">`, want: "It's &amp; done"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := parseEpisodeTitle(soup.HTMLParse(tt.page)); got != tt.want {
+                t.Errorf("title = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestEpisodeNumber(t *testing.T) {
+    tests := []struct {
+        name      string
+        link      string
+        wantNo    int
+        wantMinor string
+    }{
+        {name: "query", link: "https://www.webtoons.com/en/fantasy/sample/episode-12/viewer?title_no=1&episode_no=12", wantNo: 12},
+        {name: "query wins over path", link: "https://www.webtoons.com/en/fantasy/sample/episode-3/viewer?title_no=1&episode_no=12", wantNo: 12},
+        {name: "query bonus", link: "https://www.webtoons.com/en/fantasy/sample/viewer?title_no=1&episode_no=10.5", wantNo: 10, wantMinor: "5"},
+        {name: "path", link: "https://www.webtoons.com/en/fantasy/sample/episode-12/viewer?title_no=1", wantNo: 12},
+        {name: "path ep_", link: "https://www.webtoons.com/en/fantasy/sample/ep_7/viewer", wantNo: 7},
+        {name: "path bonus", link: "https://www.webtoons.com/en/fantasy/sample/episode-10-5/viewer?title_no=1", wantNo: 10, wantMinor: "5"},
+        {name: "path at the end", link: "https://www.webtoons.com/en/fantasy/sample/episode-4", wantNo: 4},
+        {name: "none", link: "https://www.webtoons.com/en/fantasy/sample/special/viewer?title_no=1", wantNo: 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            epNo, minor := episodeNumber(tt.link)
+            if epNo != tt.wantNo || minor != tt.wantMinor {
+                t.Errorf("episodeNumber(%s) = %d, %q, want %d, %q", tt.link, epNo, minor, tt.wantNo, tt.wantMinor)
+            }
+            if got := episodeNo(tt.link); got != tt.wantNo {
+                t.Errorf("episodeNo(%s) = %d, want %d", tt.link, got, tt.wantNo)
+            }
+        })
+    }
+}
+
+func TestNaturalLess(t *testing.T) {
+    tests := []struct {
+        a, b string
+        want bool
+    }{
+        {"2", "10", true},
+        {"10", "2", false},
+        {"layer2", "layer10", true},
+        {"layer10", "layer9", false},
+        {"a10", "b2", true},
+        {"cover", "layer1", true},
+        {"1", "1", false},
+    }
+    for _, tt := range tests {
+        if got := naturalLess(tt.a, tt.b); got != tt.want {
+            t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+        }
+    }
+}
+
+func TestGetOzPageImgLinksNumericKeys(t *testing.T) {
+    // keys 1..12 in the lexicographic order sort.Strings would give
+    keys := []string{"1", "10", "11", "12", "2", "3", "4", "5", "6", "7", "8", "9"}
+    var images []string
+    for _, k := range keys {
+        images = append(images, `"`+k+`": "img`+k+`.png"`)
+    }
+    setupTest(t, fakeSite{
+        "https://global.apis.naver.com/lineWebtoon/webtoon/motiontoonJson.json?seq=1&hashValue=0": `{"assets": {"image": {` + strings.Join(images, ", ") + `}}}`,
+    })
+
+    imgLinks, err := getOzPageImgLinks(soup.HTMLParse(testOzPage))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var want []string
+    for i := 1; i <= 12; i++ {
+        want = append(want, "https://ewebtoon-phinf.pstatic.net/motiontoon/1_0/img"+strconv.Itoa(i)+".png?type=q70")
+    }
+    if !reflect.DeepEqual(imgLinks, want) {
+        t.Errorf("image links = %v, want %v", imgLinks, want)
+    }
+}
+
+func TestParseImgLinksViewers(t *testing.T) {
+    tests := []struct {
+        name    string
+        page    string
+        want    []string
+        wantErr bool
+    }{
+        {
+            name: "single viewer",
+            page: `<div class="viewer_lst"><img data-url="https://cdn/1.jpg"><img data-url="https://cdn/2.jpg"></div>`,
+            want: []string{"https://cdn/1.jpg", "https://cdn/2.jpg"},
+        },
+        {
+            name: "split by an interstitial",
+            page: `<div class="viewer_lst"><img data-url="https://cdn/1.jpg"><img data-url="https://cdn/2.jpg"></div>
+<div class="interstitial"><img src="https://ads/banner.jpg"></div>
+<div class="viewer_lst"><img data-url="https://cdn/3.jpg"></div>
+<div class="viewer_lst"><img data-url="https://cdn/4.jpg"></div>`,
+            want: []string{"https://cdn/1.jpg", "https://cdn/2.jpg", "https://cdn/3.jpg", "https://cdn/4.jpg"},
+        },
+        {
+            name: "nested viewers",
+            page: `<div class="viewer_lst"><img data-url="https://cdn/1.jpg"><div class="viewer_lst"><img data-url="https://cdn/2.jpg"></div><img data-url="https://cdn/3.jpg"></div>`,
+            want: []string{"https://cdn/1.jpg", "https://cdn/2.jpg", "https://cdn/3.jpg"},
+        },
+        {
+            name:    "no viewer",
+            page:    `<div class="detail"><img src="https://cdn/1.jpg"></div>`,
+            wantErr: true,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            imgLinks, oz, err := parseImgLinks(soup.HTMLParse(tt.page))
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if oz {
+                t.Error("not an oz page")
+            }
+            if !reflect.DeepEqual(imgLinks, tt.want) {
+                t.Errorf("image links = %v, want %v", imgLinks, tt.want)
+            }
+        })
+    }
+}
+
+// testCMYKJPEG is an 8x8 Adobe CMYK jpeg of a single color. jpeg.Encode
+// writes an image.CMYK as YCbCr, so the file is put together by hand: every
+// block is flat, only its DC coefficient is coded
+func testCMYKJPEG(c color.CMYK) []byte {
+    var out bytes.Buffer
+    segment := func(marker byte, data ...byte) {
+        out.Write([]byte{0xff, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+        out.Write(data)
+    }
+    out.Write([]byte{0xff, 0xd8})
+    // Adobe APP14, transform 0: CMYK stored inverted
+    segment(0xee, 'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0)
+    // quantizing by 8 makes the DC coefficient the level-shifted sample
+    segment(0xdb, append([]byte{0}, bytes.Repeat([]byte{8}, 64)...)...)
+    segment(0xc0, 8, 0, 8, 0, 8, 4, 1, 0x11, 0, 2, 0x11, 0, 3, 0x11, 0, 4, 0x11, 0)
+    // standard DC table, an AC table of the end of block code only
+    segment(0xc4, 0x00, 0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+    segment(0xc4, 0x10, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+    segment(0xda, 4, 1, 0, 2, 0, 3, 0, 4, 0, 0, 63, 0)
+
+    dcCodes := []string{"00", "010", "011", "100", "101", "110", "1110", "11110", "111110"}
+    var bits strings.Builder
+    for _, v := range []uint8{c.C, c.M, c.Y, c.K} {
+        diff := int(255-v) - 128
+        size := len(strconv.FormatInt(int64(max(diff, -diff)), 2))
+        if diff == 0 {
+            size = 0
+        }
+        bits.WriteString(dcCodes[size])
+        if diff < 0 {
+            diff += 1<<size - 1
+        }
+        if size > 0 {
+            bits.WriteString(fmt.Sprintf("%0*b", size, diff))
+        }
+        bits.WriteString("0")
+    }
+    for bits.Len()%8 != 0 {
+        bits.WriteString("1")
+    }
+    scan := bits.String()
+    for i := 0; i < len(scan); i += 8 {
+        b, _ := strconv.ParseUint(scan[i:i+8], 2, 8)
+        out.WriteByte(byte(b))
+        if b == 0xff {
+            out.WriteByte(0)
+        }
+    }
+    out.Write([]byte{0xff, 0xd9})
+    return out.Bytes()
+}
+
+func TestPrepareImageCMYK(t *testing.T) {
+    setupTest(t, fakeSite{})
+    rgb := new(bytes.Buffer)
+    if err := jpeg.Encode(rgb, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name      string
+        img       []byte
+        want      color.RGBA
+        reencoded bool
+    }{
+        {name: "cyan", img: testCMYKJPEG(color.CMYK{C: 255}), want: color.RGBA{0, 255, 255, 255}, reencoded: true},
+        {name: "red", img: testCMYKJPEG(color.CMYK{M: 255, Y: 255}), want: color.RGBA{255, 0, 0, 255}, reencoded: true},
+        {name: "rgb", img: rgb.Bytes(), want: color.RGBA{0, 0, 0, 255}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config, _, err := image.DecodeConfig(bytes.NewReader(tt.img))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if (config.ColorModel == color.CMYKModel) != tt.reencoded {
+                t.Fatalf("sample CMYK = %v, want %v", config.ColorModel == color.CMYKModel, tt.reencoded)
+            }
+            prepared, err := prepareImage(tt.img)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !tt.reencoded && !bytes.Equal(prepared, tt.img) {
+                t.Error("RGB jpeg was encoded again")
+            }
+            decoded, _, err := image.Decode(bytes.NewReader(prepared))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if decoded.ColorModel() == color.CMYKModel {
+                t.Error("prepared image is still CMYK")
+            }
+            r, g, b, _ := decoded.At(4, 4).RGBA()
+            got := color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+            for i, v := range []int{int(got.R) - int(tt.want.R), int(got.G) - int(tt.want.G), int(got.B) - int(tt.want.B)} {
+                if v > 8 || v < -8 {
+                    t.Errorf("channel %d of %v, want %v", i, got, tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestImgSource(t *testing.T) {
+    tests := []struct {
+        name string
+        img  string
+        want string
+    }{
+        {name: "data-url", img: `<img src="https://cdn/real-src.jpg" data-src="https://cdn/data-src.jpg" data-url="https://cdn/data-url.jpg">`, want: "https://cdn/data-url.jpg"},
+        {name: "data-src", img: `<img src="https://cdn/real-src.jpg" data-src="https://cdn/data-src.jpg">`, want: "https://cdn/data-src.jpg"},
+        {name: "src", img: `<img src="https://cdn/real-src.jpg">`, want: "https://cdn/real-src.jpg"},
+        {name: "placeholder src", img: `<img src="https://webtoons-static.pstatic.net/image/bg_transparency.png" data-url="https://cdn/1.jpg">`, want: "https://cdn/1.jpg"},
+        {name: "spinner src only", img: `<img src="https://static/loading_spinner.gif">`, want: ""},
+        {name: "inline data", img: `<img src="data:image/gif;base64,R0lGODlhAQABAAAAACw=" data-src="https://cdn/1.jpg">`, want: "https://cdn/1.jpg"},
+        {name: "empty data-url", img: `<img data-url=" " src="https://cdn/1.jpg">`, want: "https://cdn/1.jpg"},
+        {name: "none", img: `<img alt="">`, want: ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            img := soup.HTMLParse(tt.img).Find("img")
+            if got := imgSource(img); got != tt.want {
+                t.Errorf("imgSource = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseImgLinksLazyLoaded(t *testing.T) {
+    page := `<div class="viewer_lst">
+<img src="https://webtoons-static.pstatic.net/image/bg_transparency.png" data-url="https://cdn/1.jpg">
+<img src="https://static/loading.gif" data-src="https://cdn/2.jpg">
+<img src="https://cdn/3.jpg">
+<img src="https://static/placeholder.png">
+</div>`
+    imgLinks, _, err := parseImgLinks(soup.HTMLParse(page))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"https://cdn/1.jpg", "https://cdn/2.jpg", "https://cdn/3.jpg"}
+    if !reflect.DeepEqual(imgLinks, want) {
+        t.Errorf("image links = %v, want %v", imgLinks, want)
+    }
+}
+
+func TestBatchName(t *testing.T) {
+    tests := []struct {
+        name  string
+        batch EpisodeBatch
+        width int
+        want  string
+    }{
+        {name: "range", batch: EpisodeBatch{title: "A_B", minEp: 1, maxEp: 12, episodeNos: []int{1, 12}}, width: 3, want: "epNo001-epNo012 A_B"},
+        {name: "single", batch: EpisodeBatch{title: "A", minEp: 7, maxEp: 7, episodeNos: []int{7}}, width: 4, want: "epNo0007 A"},
+        {name: "bonus", batch: EpisodeBatch{title: "A_B", minEp: 10, maxEp: 10, episodeNos: []int{10, 10}, minors: []string{"", "5"}}, width: 3, want: "epNo010-epNo010.5 A_B"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := batchName(Opts{epWidth: tt.width}, tt.batch); got != tt.want {
+                t.Errorf("batchName = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestMergeOrder(t *testing.T) {
+    tests := []struct {
+        name    string
+        file    string
+        want    float64
+        wantErr bool
+    }{
+        {name: "range", file: "epNo009-epNo012 Episode 9_Episode 12.pdf", want: 9},
+        {name: "single", file: "epNo0100 Episode 100.cbz", want: 100},
+        {name: "bonus", file: "epNo010.5-epNo011 Bonus_Episode 11.cbz", want: 10.5},
+        {name: "bonus hundredth", file: "epNo010.25 Bonus.cbz", want: 10.25},
+        {name: "part", file: "epNo001-epNo050 A_to_B_part02.pdf", want: 1},
+        {name: "title only", file: "Episode 3.pdf", wantErr: true},
+        {name: "merged", file: "merged.pdf", wantErr: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            order, err := mergeOrder(tt.file)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+            }
+            if order != tt.want {
+                t.Errorf("mergeOrder(%q) = %v, want %v", tt.file, order, tt.want)
+            }
+        })
+    }
+
+    // same order as the downloads, see episodeOrder
+    quarter, _ := mergeOrder("epNo010.25 Bonus.cbz")
+    half, _ := mergeOrder("epNo010.5 Bonus.cbz")
+    if !(quarter < half) || episodeOrder(testEpisodeURL("bonus", "10.25")) >= episodeOrder(testEpisodeURL("bonus", "10.5")) {
+        t.Errorf("10.25 (%v) should merge before 10.5 (%v) like episodeOrder", quarter, half)
+    }
+}
+
+func TestMergeFolderUnnamed(t *testing.T) {
+    setupTest(t, fakeSite{})
+    dir := t.TempDir()
+    for _, name := range []string{"epNo001 Episode 1.cbz", "notes.cbz"} {
+        if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := mergeFolder(dir, "cbz"); err == nil || !strings.Contains(err.Error(), "notes.cbz") {
+        t.Errorf("err = %v, want an error naming notes.cbz", err)
+    }
+    if temps, _ := filepath.Glob(filepath.Join(dir, ".webtoon-dl-*")); len(temps) != 0 {
+        t.Errorf("temp files left: %v", temps)
+    }
+}
+
+// testCBZ saves a cbz of that many blank pages to dir/name
+func testCBZ(t *testing.T, dir string, name string, pages int) {
+    t.Helper()
+    page := new(bytes.Buffer)
+    if err := jpeg.Encode(page, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil); err != nil {
+        t.Fatal(err)
+    }
+    comic, err := newCBZComicFile(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < pages; i++ {
+        if err := comic.addImage(page.Bytes()); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := comic.save(filepath.Join(dir, name)); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestMergeFolderDuplicates(t *testing.T) {
+    tests := []struct {
+        name      string
+        files     map[string]int
+        wantPages int
+        wantErr   string
+    }{
+        {
+            name:      "preview next to the full file",
+            files:     map[string]int{"epNo001-epNo002 A_B.cbz": 2, "epNo001-epNo002 A_B_preview.cbz": 1, "epNo003 C.cbz": 1},
+            wantPages: 3,
+        },
+        {
+            name:      "parts",
+            files:     map[string]int{"epNo001-epNo002 A_B_part01.cbz": 2, "epNo001-epNo002 A_B_part02.cbz": 1},
+            wantPages: 3,
+        },
+        {
+            name:    "two batches from the same episode",
+            files:   map[string]int{"epNo001-epNo002 A_B.cbz": 2, "epNo001-epNo010 A_to_J.cbz": 10},
+            wantErr: "same episode",
+        },
+        {
+            name:    "previews only",
+            files:   map[string]int{"epNo001 A_preview.cbz": 1},
+            wantErr: "no cbz file",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            setupTest(t, fakeSite{})
+            dir := t.TempDir()
+            for name, pages := range tt.files {
+                testCBZ(t, dir, name, pages)
+            }
+            err := mergeFolder(dir, "cbz")
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            pages, err := checkCBZ(filepath.Join(dir, "merged.cbz"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if pages != tt.wantPages {
+                t.Errorf("merged %d pages, want %d", pages, tt.wantPages)
+            }
+        })
+    }
+}
+
+func TestSaveCover(t *testing.T) {
+    const coverURL = "https://swebtoon-phinf.pstatic.net/cover.jpg"
+    cover := new(bytes.Buffer)
+    if err := jpeg.Encode(cover, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil); err != nil {
+        t.Fatal(err)
+    }
+    doc := soup.HTMLParse(`<html><head><meta property="og:image" content="` + coverURL + `"></head></html>`)
+    tests := []struct {
+        name    string
+        args    []string
+        old     string
+        want    string
+        wantErr error
+    }{
+        {name: "missing", want: cover.String()},
+        {name: "already saved", old: "old cover", want: "old cover"},
+        {name: "force", args: []string{"-force"}, old: "old cover", want: cover.String()},
+        {name: "host refused", args: []string{"-allowed-image-hosts", "webtoon-phinf.pstatic.net"}, wantErr: errImageHostRefused},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            parseTestFlags(t, tt.args...)
+            setupTest(t, fakeSite{coverURL: cover.String()})
+            outFile := filepath.Join(t.TempDir(), "cover.jpg")
+            if tt.old != "" {
+                if err := os.WriteFile(outFile, []byte(tt.old), 0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            err := saveCover(doc, outFile)
+            if tt.wantErr != nil {
+                if !errors.Is(err, tt.wantErr) {
+                    t.Fatalf("err = %v, want %v", err, tt.wantErr)
+                }
+                if _, err := os.Stat(outFile); err == nil {
+                    t.Error("refused cover was saved")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            got, err := os.ReadFile(outFile)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if string(got) != tt.want {
+                t.Errorf("cover.jpg has %d bytes, want %d", len(got), len(tt.want))
+            }
+        })
+    }
+}
+
+func TestProvenance(t *testing.T) {
+    const first = "https://www.webtoons.com/en/fantasy/sample/ep-1/viewer?title_no=1&episode_no=1"
+    const last = "https://www.webtoons.com/en/fantasy/sample/ep-2/viewer?title_no=1&episode_no=2"
+    tests := []struct {
+        name    string
+        batch   EpisodeBatch
+        wantWeb string
+    }{
+        {name: "batch", batch: EpisodeBatch{episodeNos: []int{1, 2}, links: []string{first, last}}, wantWeb: first + " " + last},
+        {name: "one episode", batch: EpisodeBatch{episodeNos: []int{1}, links: []string{first}}, wantWeb: first},
+        {name: "older manifest", batch: (Manifest{Batches: []ManifestBatch{{MinEp: 1, MaxEp: 2, Episodes: []ManifestEpisode{{No: 1}, {No: 2}}}}}).episodeBatches(1, 2)[0], wantWeb: testListURL},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            comic, err := newCBZComicFile(dir)
+            if err != nil {
+                t.Fatal(err)
+            }
+            firstURL, lastURL := tt.batch.sourceURLs(testListURL)
+            comic.setSource(firstURL, lastURL, "notes", time.Now())
+            if err := comic.save(filepath.Join(dir, "a.cbz")); err != nil {
+                t.Fatal(err)
+            }
+            r, err := zip.OpenReader(filepath.Join(dir, "a.cbz"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer r.Close()
+            rc, err := r.Open("ComicInfo.xml")
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer rc.Close()
+            var info ComicInfo
+            if err := xml.NewDecoder(rc).Decode(&info); err != nil {
+                t.Fatal(err)
+            }
+            if info.Web != tt.wantWeb {
+                t.Errorf("Web = %q, want %q", info.Web, tt.wantWeb)
+            }
+        })
+    }
+}
+
+func TestUniformWidthParts(t *testing.T) {
+    img := func(width int) string {
+        buff := new(bytes.Buffer)
+        if err := jpeg.Encode(buff, image.NewRGBA(image.Rect(0, 0, width, 16)), nil); err != nil {
+            t.Fatal(err)
+        }
+        return buff.String()
+    }
+    const cdn = "https://webtoon-phinf.pstatic.net/"
+    setupTest(t, fakeSite{cdn + "1.jpg": img(32), cdn + "2.jpg": img(32), cdn + "3.jpg": img(64)})
+    setTestFlag(t, "uniform-width", "true")
+    episodeBatch := EpisodeBatch{
+        imgLinks:   []string{cdn + "1.jpg", cdn + "2.jpg", cdn + "3.jpg"},
+        notes:      []string{""},
+        episodeNos: []int{1},
+        titles:     []string{"Episode 1"},
+        pageCounts: []int{3},
+        minEp:      1,
+        maxEp:      1,
+    }
+    opts := Opts{format: "cbz", logger: log.New(io.Discard, "", 0)}
+    images := newBatchImages(episodeBatch, opts.logger)
+    progress := newProgress(3)
+    dir := t.TempDir()
+    // the second part alone is mostly 64 wide, the batch is 32 wide
+    savePart("sample", opts, episodeBatch, filepath.Join(dir, "part1"), 0, 2, nil, nil, nil, images, progress)
+    savePart("sample", opts, episodeBatch, filepath.Join(dir, "part2"), 2, 3, nil, nil, nil, images, progress)
+    r, err := zip.OpenReader(filepath.Join(dir, "part2.cbz"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r.Close()
+    pages := 0
+    for _, f := range r.File {
+        if !strings.HasSuffix(f.Name, ".jpg") {
+            continue
+        }
+        pages++
+        rc, err := f.Open()
+        if err != nil {
+            t.Fatal(err)
+        }
+        d, _, err := image.DecodeConfig(rc)
+        rc.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if d.Width != 32 {
+            t.Errorf("%s is %d wide, want 32", f.Name, d.Width)
+        }
+    }
+    if pages != 1 {
+        t.Errorf("part2.cbz has %d pages, want 1", pages)
+    }
+}
+
+func TestComicFileAbort(t *testing.T) {
+    setupTest(t, fakeSite{})
+    page := new(bytes.Buffer)
+    if err := jpeg.Encode(page, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil); err != nil {
+        t.Fatal(err)
+    }
+    for _, format := range []string{"pdf", "cbz", "pdf-zip", "dir"} {
+        t.Run(format, func(t *testing.T) {
+            dir := t.TempDir()
+            comic := getComicFile(format, dir)
+            if pdfZip, ok := comic.(*PDFZipComicFile); ok {
+                if err := pdfZip.setEpisode(1, ""); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if err := comic.addImage(page.Bytes()); err != nil {
+                t.Fatal(err)
+            }
+            comic.abort()
+            entries, err := os.ReadDir(dir)
+            if err != nil {
+                t.Fatal(err)
+            }
+            for _, entry := range entries {
+                t.Errorf("left in %s: %s", dir, entry.Name())
+            }
+        })
+    }
+}
+
+func TestLoadConfig(t *testing.T) {
+    tests := []struct {
+        name       string
+        config     string
+        args       []string
+        wantFormat string
+        wantE      int
+        wantForce  bool
+        wantHeader []string
+        wantErr    string
+    }{
+        {
+            name: "tables, arrays and comments",
+            config: `# defaults
+format = "cbz" # for komga
+header = [
+    "Accept-Language: en",
+    'DNT: 1', # literal string
+]
+
+[network]
+E = 1_0
+force = true
+`,
+            wantFormat: "cbz",
+            wantE:      10,
+            wantForce:  true,
+            wantHeader: []string{"Accept-Language: en", "DNT: 1"},
+        },
+        {
+            name:       "command line wins",
+            config:     "format = \"cbz\"\nE = 10\n",
+            args:       []string{"-E", "3"},
+            wantFormat: "cbz",
+            wantE:      3,
+        },
+        {
+            name:       "-E wins over the threads alias",
+            config:     "threads = 10\n",
+            args:       []string{"-E", "3"},
+            wantFormat: "pdf",
+            wantE:      3,
+        },
+        {
+            name:       "-threads wins over E",
+            config:     "[network]\nE = 10\n",
+            args:       []string{"-threads", "3"},
+            wantFormat: "pdf",
+            wantE:      3,
+        },
+        {name: "alias from the file", config: "threads = 7\n", wantFormat: "pdf", wantE: 7},
+        {name: "dotted key", config: "network.E = 4\n", wantFormat: "pdf", wantE: 4},
+        {name: "flag and alias in the file", config: "E = 4\n[network]\nthreads = 8\n", wantErr: "already set"},
+        {name: "bare string", config: "format = cbz\n", wantErr: "line 1"},
+        {name: "unknown flag", config: "formats = \"cbz\"\n", wantErr: "unknown flag"},
+        {name: "unknown flag in a table", config: "[network]\nformats = \"cbz\"\n", wantErr: "network.formats: unknown flag"},
+        {name: "unterminated array", config: "header = [\"A: b\"\n", wantErr: "line"},
+        {name: "array of tables", config: "[[network]]\nE = 4\n", wantErr: "arrays of tables"},
+        {name: "config", config: "config = \"other.toml\"\n", wantErr: "can't be set"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            file := filepath.Join(t.TempDir(), "webtoon-dl.toml")
+            if err := os.WriteFile(file, []byte(tt.config), 0644); err != nil {
+                t.Fatal(err)
+            }
+            fs := flag.NewFlagSet("webtoon-dl", flag.ContinueOnError)
+            format := fs.String("format", "pdf", "")
+            e := fs.Int("E", 1, "")
+            fs.IntVar(e, "threads", 1, "")
+            force := fs.Bool("force", false, "")
+            fs.String("config", "", "")
+            var header []string
+            fs.Func("header", "", func(value string) error {
+                header = append(header, value)
+                return nil
+            })
+            if err := fs.Parse(tt.args); err != nil {
+                t.Fatal(err)
+            }
+
+            err := loadConfig(fs, file)
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if *format != tt.wantFormat || *e != tt.wantE || *force != tt.wantForce || !reflect.DeepEqual(header, tt.wantHeader) {
+                t.Errorf("format %q E %d force %v header %v, want %q %d %v %v", *format, *e, *force, header, tt.wantFormat, tt.wantE, tt.wantForce, tt.wantHeader)
+            }
+        })
+    }
+}
+
+func TestTarget(t *testing.T) {
+    tests := []struct {
+        name          string
+        args          []string
+        wantFormat    string
+        wantLayout    string
+        wantDimension int
+        wantCover     bool
+    }{
+        {name: "kobo", args: []string{"-target", "kobo"}, wantFormat: "cbz", wantDimension: 2000},
+        {name: "kindle", args: []string{"-target", "kindle"}, wantFormat: "pdf", wantDimension: 2000},
+        {name: "komga", args: []string{"-target", "komga"}, wantFormat: "cbz", wantCover: true},
+        {name: "tachiyomi", args: []string{"-target", "tachiyomi"}, wantFormat: "dir", wantLayout: "tachiyomi"},
+        {name: "explicit format", args: []string{"-target", "tachiyomi", "-format", "cbz"}, wantFormat: "cbz"},
+        {name: "explicit dimension", args: []string{"-target", "kobo", "-max-image-dimension", "0"}, wantFormat: "cbz"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            opts := parseTestFlags(t, tt.args...)
+            if opts.format != tt.wantFormat || *Layout != tt.wantLayout || *MaxImageDimension != tt.wantDimension || *Cover != tt.wantCover || *Provenance != tt.wantCover {
+                t.Errorf("format %q layout %q max-image-dimension %d cover %v provenance %v", opts.format, *Layout, *MaxImageDimension, *Cover, *Provenance)
+            }
+        })
+    }
+}
+
+func TestGetReaderTarget(t *testing.T) {
+    tests := []struct {
+        name    string
+        wantErr string
+    }{
+        {name: "kobo"},
+        {name: "epub", wantErr: "target epub is not supported"},
+        {name: "mobi", wantErr: "target mobi is not supported"},
+        {name: "nook", wantErr: "unknown target nook"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := getReaderTarget(tt.name)
+            if tt.wantErr == "" && err != nil {
+                t.Fatal(err)
+            }
+            if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+                t.Errorf("err = %v, want %q", err, tt.wantErr)
+            }
+        })
+    }
+}
+
+func TestEpisodeWidth(t *testing.T) {
+    tests := []struct {
+        name     string
+        files    []string
+        latest   int
+        padWidth string
+        want     int
+    }{
+        {name: "new series", latest: 42, want: 3},
+        {name: "new long series", latest: 1200, want: 4},
+        {name: "kept past 999", files: []string{"epNo001-epNo010 A.pdf", "epNo1000 B.pdf"}, latest: 1000, want: 3},
+        {name: "volumes", files: []string{"Vol_1/epNo0001 A.cbz"}, latest: 20, want: 4},
+        {name: "tachiyomi folders", files: []string{"Episode 01/001.jpg"}, latest: 200, want: 2},
+        {name: "ep-pad-width", files: []string{"epNo001 A.pdf"}, latest: 1000, padWidth: "5", want: 5},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if tt.padWidth != "" {
+                setTestFlag(t, "ep-pad-width", tt.padWidth)
+            }
+            dir := t.TempDir()
+            for _, file := range tt.files {
+                path := filepath.Join(dir, file)
+                os.MkdirAll(filepath.Dir(path), 0755)
+                if err := os.WriteFile(path, nil, 0644); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if got := episodeWidth(tt.latest, dir); got != tt.want {
+                t.Errorf("episodeWidth = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestLastSyncedEpisode(t *testing.T) {
+    batches := []EpisodeBatch{
+        {episodeNos: []int{11, 12}, minEp: 11, maxEp: 12},
+        {episodeNos: []int{13, 14}, minEp: 13, maxEp: 14},
+    }
+    tests := []struct {
+        name   string
+        saved  []string
+        minEp  int
+        stored int
+        want   int
+    }{
+        {name: "all saved", saved: []string{"11", "12", "13", "14"}, minEp: 11, stored: 10, want: 14},
+        {name: "gap holds it", saved: []string{"11", "13", "14"}, minEp: 11, stored: 10, want: 11},
+        {name: "nothing saved", minEp: 11, stored: 10, want: 10},
+        {name: "lower min-ep", saved: []string{"11", "12"}, minEp: 11, stored: 40, want: 40},
+        {name: "first sync", saved: []string{"11", "12", "13"}, minEp: 11, want: 13},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            saved := make(map[string]bool)
+            for _, label := range tt.saved {
+                saved[label] = true
+            }
+            if got := lastSyncedEpisode(batches, saved, tt.minEp, tt.stored); got != tt.want {
+                t.Errorf("lastSyncedEpisode = %d, want %d", got, tt.want)
+            }
+        })
+    }
+}